@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+)
+
+// colorizer colors command output with ANSI escape sequences when enabled.
+type colorizer struct {
+	enabled bool
+}
+
+// newColorizer returns a colorizer enabled if f is a terminal, unless noColor
+// is set or the NO_COLOR environment variable is not empty (see
+// https://no-color.org).
+func newColorizer(f *os.File, noColor bool) colorizer {
+	return colorizer{
+		enabled: !noColor && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(f.Fd())), // nolint:gosec // dismiss G115
+	}
+}
+
+func (z colorizer) color(code, s string) string {
+	if !z.enabled {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func (z colorizer) green(s string) string { return z.color(ansiGreen, s) }
+
+func (z colorizer) red(s string) string { return z.color(ansiRed, s) }
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestColorizer(t *testing.T) {
+	require.Equal(t, "\x1b[32mPASS\x1b[0m", colorizer{enabled: true}.green("PASS"))
+	require.Equal(t, "\x1b[31mFAIL\x1b[0m", colorizer{enabled: true}.red("FAIL"))
+	require.Equal(t, "FAIL", colorizer{}.red("FAIL"))
+
+	// Regular files are not terminals.
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	defer f.Close()
+	require.False(t, newColorizer(f, false).enabled)
+}
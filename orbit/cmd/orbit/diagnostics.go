@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/build"
+	"github.com/fleetdm/fleet/v4/orbit/pkg/constant"
+	"github.com/fleetdm/fleet/v4/orbit/pkg/update"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// Openframe command that collects the agent state into a tar.gz bundle that
+// can be attached to support tickets.
+var diagnosticsCommand = &cli.Command{
+	Name:  "diagnostics",
+	Usage: "Collect logs, configuration and host information into a tar.gz bundle for support",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "Path of the tar.gz bundle to create (default: orbit-diagnostics-<timestamp>.tar.gz in the current directory)",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Print the files that would be collected without creating the bundle or running osqueryd",
+		},
+	}, openframeFlags()...),
+	Action: withExitCodes(func(c *cli.Context) error {
+		rootDir := c.String("root-dir")
+		now := time.Now()
+
+		output := c.String("output")
+		if output == "" {
+			// We can't use ISO 8601/RFC 3339 because NTFS and FAT do not allow colons in filenames
+			output = fmt.Sprintf("orbit-diagnostics-%s.tar.gz", now.UTC().Format("2006-01-02T15-04-05"))
+		}
+
+		if c.Bool("dry-run") {
+			fmt.Printf("Would write %s with:\n", output)
+			return collectDiagnostics(c, &diagnosticsBundle{plan: os.Stdout}, rootDir)
+		}
+
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, constant.DefaultFileMode)
+		if err != nil {
+			return fmt.Errorf("failed to create diagnostics bundle: %w", err)
+		}
+		if err := writeDiagnosticsBundle(c, f, rootDir, now); err != nil {
+			f.Close()
+			os.Remove(output)
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write diagnostics bundle: %w", err)
+		}
+
+		fmt.Println(output)
+		return nil
+	}),
+}
+
+func writeDiagnosticsBundle(c *cli.Context, w io.Writer, rootDir string, now time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	if err := collectDiagnostics(c, &diagnosticsBundle{tw: tw, modTime: now}, rootDir); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write diagnostics bundle: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to write diagnostics bundle: %w", err)
+	}
+	return nil
+}
+
+// diagnosticsBundle writes the files of the diagnostics tar.gz bundle, or
+// only lists them to plan if set (dry run).
+type diagnosticsBundle struct {
+	tw      *tar.Writer
+	modTime time.Time
+	plan    io.Writer
+}
+
+func (d *diagnosticsBundle) addFile(name string, data []byte) error {
+	return d.add(name, int64(len(data)), bytes.NewReader(data))
+}
+
+// addFileFromDisk adds the file at path, of the given size, streaming its
+// contents since osquery logs can be large. The file isn't read in dry runs.
+func (d *diagnosticsBundle) addFileFromDisk(name, path string, size int64) error {
+	if d.plan != nil {
+		return d.add(name, size, nil)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.add(name, size, f)
+}
+
+// add writes size bytes from r as the file name, or lists it if dry run.
+func (d *diagnosticsBundle) add(name string, size int64, r io.Reader) error {
+	if d.plan != nil {
+		_, err := fmt.Fprintf(d.plan, "  %s (%d bytes)\n", name, size)
+		return err
+	}
+	if err := d.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o600,
+		ModTime:  d.modTime,
+	}); err != nil {
+		return fmt.Errorf("failed to write %s header: %w", name, err)
+	}
+	// Only copy size bytes, in case the file grew since it was listed.
+	if _, err := io.CopyN(d.tw, r, size); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (d *diagnosticsBundle) addJSON(name string, v interface{}) error {
+	data, err := marshalOutput(v, true)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	return d.addFile(name, data)
+}
+
+// diagnosticsSecretFiles are the files in the root directory whose contents
+// are never included in the bundle, only their presence and length.
+var diagnosticsSecretFiles = []string{
+	constant.OrbitNodeKeyFileName,
+	constant.OsqueryEnrollSecretFileName,
+	constant.DesktopTokenFileName,
+}
+
+// diagnosticsQueries are the osquery queries whose results are included in
+// the bundle.
+var diagnosticsQueries = map[string]string{
+	"osquery_info": "SELECT version, build_platform, build_distro FROM osquery_info",
+	"system_info":  "SELECT uuid, hardware_serial, hostname, computer_name FROM system_info",
+	"os_version":   "SELECT name, version, build, platform, arch FROM os_version",
+}
+
+// secretFileInfo is what the bundle records about a secret file.
+type secretFileInfo struct {
+	Present bool `json:"present"`
+	Length  int  `json:"length"`
+}
+
+func collectDiagnostics(c *cli.Context, d *diagnosticsBundle, rootDir string) error {
+	if err := d.addJSON("orbit.json", map[string]string{
+		"version":  build.Version,
+		"commit":   build.Commit,
+		"date":     build.Date,
+		"os":       runtime.GOOS,
+		"arch":     runtime.GOARCH,
+		"root_dir": rootDir,
+	}); err != nil {
+		return err
+	}
+
+	// Configuration files.
+	for _, name := range []string{update.MetadataFileName, constant.ServerOverridesFileName} {
+		data, err := os.ReadFile(filepath.Join(rootDir, name))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Warn().Err(err).Str("file", name).Msg("skipping file in diagnostics bundle")
+			}
+			continue
+		}
+		if err := d.addFile(name, data); err != nil {
+			return err
+		}
+	}
+
+	// Secret files, redacted.
+	secrets := make(map[string]secretFileInfo, len(diagnosticsSecretFiles))
+	for _, name := range diagnosticsSecretFiles {
+		data, err := os.ReadFile(filepath.Join(rootDir, name))
+		if err != nil {
+			secrets[name] = secretFileInfo{Present: !errors.Is(err, os.ErrNotExist)}
+			continue
+		}
+		secrets[name] = secretFileInfo{Present: true, Length: len(data)}
+	}
+	if err := d.addJSON("secrets.json", secrets); err != nil {
+		return err
+	}
+
+	// Enrollment and process state.
+	if st, err := getAgentStatus(rootDir); err != nil {
+		log.Warn().Err(err).Msg("skipping status in diagnostics bundle")
+	} else if err := d.addJSON("status.json", st); err != nil {
+		return err
+	}
+
+	// osquery version and host information.
+	if d.plan != nil {
+		names := make([]string, 0, len(diagnosticsQueries))
+		for name := range diagnosticsQueries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if _, err := fmt.Fprintf(d.plan, "  osquery.json (queries: %s)\n", strings.Join(names, ", ")); err != nil {
+			return err
+		}
+	} else if err := d.addJSON("osquery.json", collectDiagnosticsQueries(c)); err != nil {
+		return err
+	}
+
+	// osquery logs.
+	logDir := filepath.Join(rootDir, "osquery_log")
+	if err := filepath.WalkDir(logDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && path == logDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+		return d.addFileFromDisk(filepath.ToSlash(rel), path, info.Size())
+	}); err != nil {
+		return fmt.Errorf("failed to collect osquery logs: %w", err)
+	}
+
+	return nil
+}
+
+// collectDiagnosticsQueries runs diagnosticsQueries with osqueryd and returns
+// the rows (or the error) of each query keyed by name. A missing osqueryd is
+// reported in the results instead of failing the whole bundle.
+func collectDiagnosticsQueries(c *cli.Context) map[string]interface{} {
+	results := make(map[string]interface{}, len(diagnosticsQueries))
+
+	osquerydPath, err := locateOsqueryd(c)
+	if err != nil {
+		results["error"] = err.Error()
+		return results
+	}
+	results["osqueryd_path"] = osquerydPath
+
+	tmpDBPath, removeDB := newTempDB("", "diagnostics")
+	defer removeDB()
+
+	for name, sql := range diagnosticsQueries {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		rows, err := runOsqueryQuery(ctx, osquerydPath, tmpDBPath, sql)
+		cancel()
+		if err != nil {
+			results[name] = map[string]string{"error": err.Error()}
+			continue
+		}
+		results[name] = rows
+	}
+	return results
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/constant"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestDiagnosticsCommand(t *testing.T) {
+	rootDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, constant.OrbitNodeKeyFileName), []byte("supersecretnodekey"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, constant.ServerOverridesFileName), []byte(`{"orbit_channel":"edge"}`), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, "osquery_log"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "osquery_log", "osqueryd.results.log"), []byte("result\n"), 0o600))
+
+	osquerydPath := writeFakeOsqueryd(t, `[{"version":"5.12.1"}]`, 0)
+	output := filepath.Join(t.TempDir(), "bundle.tar.gz")
+
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{&cli.StringFlag{Name: "root-dir"}}
+	app.Commands = []*cli.Command{diagnosticsCommand}
+	require.NoError(t, app.Run([]string{
+		"orbit", "--root-dir", rootDir, "diagnostics",
+		"--output", output, "--openframe-mode", "--openframe-osquery-path", osquerydPath,
+	}))
+
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(b)
+	}
+
+	require.Contains(t, files, "orbit.json")
+	require.Contains(t, files, "status.json")
+	require.Equal(t, `{"orbit_channel":"edge"}`, files[constant.ServerOverridesFileName])
+	require.Equal(t, "result\n", files["osquery_log/osqueryd.results.log"])
+	require.Contains(t, files["osquery.json"], "5.12.1")
+
+	var secrets map[string]secretFileInfo
+	require.NoError(t, json.Unmarshal([]byte(files["secrets.json"]), &secrets))
+	require.Equal(t, secretFileInfo{Present: true, Length: len("supersecretnodekey")}, secrets[constant.OrbitNodeKeyFileName])
+	require.Equal(t, secretFileInfo{}, secrets[constant.OsqueryEnrollSecretFileName])
+	for name, content := range files {
+		require.NotContains(t, content, "supersecretnodekey", name)
+	}
+}
+
+func TestDiagnosticsCommandDryRun(t *testing.T) {
+	rootDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, constant.OrbitNodeKeyFileName), []byte("supersecretnodekey"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, "osquery_log"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "osquery_log", "osqueryd.results.log"), []byte("result\n"), 0o600))
+	output := filepath.Join(t.TempDir(), "bundle.tar.gz")
+
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{&cli.StringFlag{Name: "root-dir"}}
+	app.Commands = []*cli.Command{diagnosticsCommand}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{
+			"orbit", "--root-dir", rootDir, "diagnostics", "--output", output, "--dry-run",
+			"--openframe-mode", "--openframe-osquery-path", filepath.Join(t.TempDir(), "missing"),
+		}))
+	})
+
+	require.NoFileExists(t, output)
+	require.Contains(t, out, "Would write "+output+" with:")
+	require.Contains(t, out, "  secrets.json (")
+	require.Contains(t, out, "  osquery.json (queries: os_version, osquery_info, system_info)")
+	require.Contains(t, out, "  osquery_log/osqueryd.results.log (7 bytes)")
+	require.NotContains(t, out, "supersecretnodekey")
+}
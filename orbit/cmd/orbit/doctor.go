@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/constant"
+	"github.com/urfave/cli/v2"
+)
+
+// Openframe command that runs a set of health checks to diagnose common
+// OpenFrame agent misconfigurations.
+var doctorCommand = &cli.Command{
+	Name:  "doctor",
+	Usage: "Check the agent installation for common problems",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output check results in JSON format",
+		},
+		prettyFlag(),
+		&cli.BoolFlag{
+			Name:  "no-color",
+			Usage: "Do not color PASS/FAIL (also disabled when NO_COLOR is set or stdout is not a terminal)",
+		},
+	}, openframeFlags()...),
+	Action: withExitCodes(func(c *cli.Context) error {
+		rootDir := c.String("root-dir")
+
+		var osquerydPath string
+		checks := []doctorCheck{
+			{
+				name: "osqueryd binary is executable",
+				hint: "install osqueryd or point --openframe-osquery-path to an executable osqueryd binary",
+				run: func() error {
+					p, err := locateOsqueryd(c)
+					if err != nil {
+						return err
+					}
+					if err := checkExecutable(p); err != nil {
+						return err
+					}
+					osquerydPath = p
+					return nil
+				},
+			},
+			{
+				name: "root directory is writable",
+				hint: fmt.Sprintf("run as root/administrator or fix the permissions of %s", rootDir),
+				run: func() error {
+					return checkDirWritable(rootDir)
+				},
+			},
+			{
+				name: "temporary directory is usable",
+				hint: fmt.Sprintf("make sure %s exists and is writable", os.TempDir()),
+				run: func() error {
+					return checkDirWritable(os.TempDir())
+				},
+			},
+			{
+				name: "osqueryd can run a query",
+				hint: "run `orbit query \"SELECT 1\"` to see the osqueryd output",
+				run: func() error {
+					if osquerydPath == "" {
+						return errors.New("osqueryd binary not available")
+					}
+					ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+					defer cancel()
+					tmpDBPath, removeDB := newTempDB("", "doctor")
+					defer removeDB()
+					rows, err := runOsqueryQuery(ctx, osquerydPath, tmpDBPath, "SELECT 1")
+					if err != nil {
+						return err
+					}
+					if len(rows) != 1 {
+						return fmt.Errorf("expected 1 row, got %d", len(rows))
+					}
+					return nil
+				},
+			},
+			{
+				name: "enrollment secret files have restricted permissions",
+				hint: fmt.Sprintf("run chmod %o on the reported files", constant.DefaultFileMode),
+				run: func() error {
+					return checkSecretFilePermissions(rootDir)
+				},
+			},
+		}
+
+		colors := newColorizer(os.Stdout, c.Bool("no-color"))
+		var failed int
+		results := make([]doctorCheckResult, 0, len(checks))
+		for _, check := range checks {
+			result := doctorCheckResult{Name: check.name, Passed: true}
+			if err := check.run(); err != nil {
+				failed++
+				result = doctorCheckResult{Name: check.name, Error: err.Error(), Hint: check.hint}
+			}
+			results = append(results, result)
+			if c.Bool("json") {
+				continue
+			}
+			if !result.Passed {
+				fmt.Printf("%s %s: %s\n", colors.red("FAIL"), result.Name, result.Error)
+				fmt.Printf("     hint: %s\n", result.Hint)
+				continue
+			}
+			fmt.Printf("%s %s\n", colors.green("PASS"), result.Name)
+		}
+		if c.Bool("json") {
+			out, err := marshalEnvelope(c, results, "")
+			if err != nil {
+				return fmt.Errorf("failed to marshal check results: %w", err)
+			}
+			fmt.Println(string(out))
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+		}
+		return nil
+	}),
+}
+
+// doctorCheckResult is the outcome of a doctorCheck, as output with --json.
+type doctorCheckResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// doctorCheck is a single check run by the doctor command.
+type doctorCheck struct {
+	name string
+	// hint is the remediation printed when the check fails.
+	hint string
+	run  func() error
+}
+
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	// Windows doesn't have an executable permission bit.
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable (mode %s)", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, "orbit-doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkSecretFilePermissions returns an error if any of the enrollment secret
+// files in rootDir can be accessed by group or others. Files that don't exist
+// are ignored.
+func checkSecretFilePermissions(rootDir string) error {
+	// File permissions on Windows are managed via ACLs.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	for _, name := range []string{constant.OrbitNodeKeyFileName, constant.OsqueryEnrollSecretFileName} {
+		p := filepath.Join(rootDir, name)
+		info, err := os.Stat(p)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if info.Mode().Perm()&0o077 != 0 {
+			return fmt.Errorf("%s has mode %s", p, info.Mode().Perm())
+		}
+	}
+	return nil
+}
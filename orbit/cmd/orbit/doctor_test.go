@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/constant"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSecretFilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on Windows")
+	}
+	rootDir := t.TempDir()
+	require.NoError(t, checkSecretFilePermissions(rootDir))
+
+	p := filepath.Join(rootDir, constant.OsqueryEnrollSecretFileName)
+	require.NoError(t, os.WriteFile(p, []byte("secret"), 0o600))
+	require.NoError(t, checkSecretFilePermissions(rootDir))
+
+	require.NoError(t, os.Chmod(p, 0o644))
+	require.ErrorContains(t, checkSecretFilePermissions(rootDir), p)
+}
+
+func TestCheckExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("executable bit is not checked on Windows")
+	}
+	dir := t.TempDir()
+	require.Error(t, checkExecutable(dir))
+	require.Error(t, checkExecutable(filepath.Join(dir, "missing")))
+
+	p := filepath.Join(dir, "osqueryd")
+	require.NoError(t, os.WriteFile(p, nil, 0o644))
+	require.ErrorContains(t, checkExecutable(p), "is not executable")
+
+	require.NoError(t, os.Chmod(p, 0o755))
+	require.NoError(t, checkExecutable(p))
+}
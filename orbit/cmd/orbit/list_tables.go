@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Openframe command that lists the tables available in the osqueryd build,
+// to help crafting queries for the query command.
+var listTablesCommand = &cli.Command{
+	Name:  "list-tables",
+	Usage: "List the tables available in osqueryd",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output table names as a JSON array",
+		},
+		prettyFlag(),
+		&cli.StringFlag{
+			Name:  "extensions-autoload",
+			Usage: "Path to an osquery extensions autoload file, to include the tables provided by extensions",
+		},
+	}, openframeFlags()...),
+	Action: withExitCodes(func(c *cli.Context) error {
+		osquerydPath, err := locateOsqueryd(c)
+		if err != nil {
+			return err
+		}
+
+		tmpDBPath, removeDB := newTempDB("", "tables")
+		defer removeDB()
+
+		var opts []queryOption
+		if p := c.String("extensions-autoload"); p != "" {
+			opts = append(opts, withExtensionsAutoload(p))
+		}
+
+		tables, err := getOsqueryTables(context.Background(), osquerydPath, tmpDBPath, opts...)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("failed to list tables: %w", err), exitOsqueryFailed)
+		}
+
+		if c.Bool("json") {
+			out, err := marshalEnvelope(c, tables, "")
+			if err != nil {
+				return fmt.Errorf("failed to marshal tables: %w", err)
+			}
+			fmt.Println(string(out))
+		} else if len(tables) > 0 {
+			fmt.Println(strings.Join(tables, "\n"))
+		}
+		return nil
+	}),
+}
+
+// getOsqueryTables returns the names of the tables registered in osqueryd,
+// sorted by name.
+func getOsqueryTables(ctx context.Context, osquerydPath string, dbPath string, opts ...queryOption) ([]string, error) {
+	rows, err := runOsqueryQuery(ctx, osquerydPath, dbPath,
+		`SELECT name FROM osquery_registry WHERE registry = 'table' ORDER BY name`, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0, len(rows))
+	for _, row := range rows {
+		name, ok := row["name"].(string)
+		if !ok {
+			return nil, errors.New("name field not found or not a string")
+		}
+		tables = append(tables, name)
+	}
+	return tables, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOsqueryTables(t *testing.T) {
+	osquerydPath := writeFakeOsqueryd(t, `[{"name":"os_version"},{"name":"system_info"}]`, 0)
+	tables, err := getOsqueryTables(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"os_version", "system_info"}, tables)
+
+	osquerydPath = writeFakeOsqueryd(t, `[]`, 0)
+	tables, err = getOsqueryTables(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"))
+	require.NoError(t, err)
+	require.Empty(t, tables)
+	require.NotNil(t, tables)
+
+	osquerydPath = writeFakeOsqueryd(t, `[{"registry":"table"}]`, 0)
+	_, err = getOsqueryTables(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"))
+	require.ErrorContains(t, err, "name field not found")
+}
@@ -0,0 +1,26 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+)
+
+var ioPlatformUUIDRegexp = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// getNativeHostUUID returns the IOPlatformUUID reported by ioreg.
+func getNativeHostUUID() (string, error) {
+	out, err := exec.Command("/usr/sbin/ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", fmt.Errorf("run ioreg: %w", err)
+	}
+	m := ioPlatformUUIDRegexp.FindSubmatch(out)
+	if m == nil {
+		return "", errors.New("IOPlatformUUID not found in ioreg output")
+	}
+	return string(m[1]), nil
+}
@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// getNativeHostUUID returns the hardware UUID exposed by the kernel's DMI
+// interface. Reading it usually requires root.
+func getNativeHostUUID() (string, error) {
+	b, err := os.ReadFile("/sys/class/dmi/id/product_uuid")
+	if err != nil {
+		return "", fmt.Errorf("read product_uuid: %w", err)
+	}
+	hostUUID := strings.TrimSpace(string(b))
+	if hostUUID == "" {
+		return "", errors.New("product_uuid is empty")
+	}
+	return strings.ToUpper(hostUUID), nil
+}
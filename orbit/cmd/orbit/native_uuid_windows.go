@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/fleetdm/fleet/v4/orbit/pkg/platform"
+)
+
+// getNativeHostUUID returns Win32_ComputerSystemProduct.UUID from WMI, falling
+// back to reading the SMBIOS tables directly.
+func getNativeHostUUID() (string, error) {
+	hostUUID, _, err := platform.GetSMBiosUUID()
+	if err != nil {
+		return "", err
+	}
+	return hostUUID, nil
+}
@@ -12,7 +12,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,11 +24,14 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/ee/orbit/pkg/hostidentity"
@@ -92,6 +97,16 @@ func main() {
 		versionCommand,
 		shellCommand,
 		uuidCommand,
+		serialCommand,
+		queryCommand,
+		identityCommand,
+		statusCommand,
+		doctorCommand,
+		diagnosticsCommand,
+		osqueryFlagsCommand,
+		listTablesCommand,
+		selfTestCommand,
+		whoamiCommand,
 	}
 	app.Flags = []cli.Flag{
 		&cli.StringFlag{
@@ -100,6 +115,10 @@ func main() {
 			Value:   "", // need to check if explicitly set
 			EnvVars: []string{"ORBIT_ROOT_DIR"},
 		},
+		&cli.BoolFlag{
+			Name:  "root-dir-from-service",
+			Usage: "Use the root directory of the installed orbit service (systemd unit or launchd daemon) instead of the default",
+		},
 		&cli.BoolFlag{
 			Name:    "insecure",
 			Usage:   "Disable TLS certificate verification",
@@ -189,6 +208,12 @@ func main() {
 			Usage:   "Log to this file path in addition to stderr",
 			EnvVars: []string{"ORBIT_LOG_FILE"},
 		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "Log output format, console or json",
+			Value:   "console",
+			EnvVars: []string{"ORBIT_LOG_FORMAT"},
+		},
 		&cli.BoolFlag{
 			Name:    "fleet-desktop",
 			Usage:   "Launch Fleet Desktop application (flag currently only used on darwin)",
@@ -271,16 +296,34 @@ func main() {
 		},
 	}
 	app.Before = func(c *cli.Context) error {
-		// handle old installations, which had default root dir set to /var/lib/orbit
-		if c.String("root-dir") == "" {
-			rootDir := update.DefaultOptions.RootDirectory
+		logFormat := c.String("log-format")
+		if logFormat != "console" && logFormat != "json" {
+			return cli.Exit(fmt.Sprintf("invalid log-format %q, supported: console, json", logFormat), exitFailure)
+		}
+		log.Logger = log.Output(newLogWriter(os.Stderr, logFormat))
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		if c.Bool("debug") {
+			zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		}
 
-			executable, err := os.Executable()
+		if c.Bool("root-dir-from-service") {
+			// Only a root-dir passed on the command line conflicts, the service
+			// root directory takes precedence over ORBIT_ROOT_DIR.
+			if _, ok := rootDirArg(os.Args[1 : len(os.Args)-c.NArg()]); ok {
+				return cli.Exit("root-dir and root-dir-from-service cannot be used together", exitFailure)
+			}
+			rootDir, err := serviceRootDir()
 			if err != nil {
-				return fmt.Errorf("failed to get orbit executable: %w", err)
+				return cli.Exit(fmt.Sprintf("failed to get root-dir from service: %v", err), exitFailure)
+			}
+			if err := c.Set("root-dir", rootDir); err != nil {
+				return cli.Exit(fmt.Sprintf("failed to set root-dir: %v", err), exitFailure)
 			}
-			if strings.HasPrefix(executable, "/var/lib/orbit") {
-				rootDir = "/var/lib/orbit"
+		}
+		if c.String("root-dir") == "" {
+			rootDir, err := resolveRootDir()
+			if err != nil {
+				return err
 			}
 			if err := c.Set("root-dir", rootDir); err != nil {
 				return fmt.Errorf("failed to set root-dir: %w", err)
@@ -313,29 +356,23 @@ func main() {
 				// "write /dev/stderr: The handle is invalid" (see
 				// #3100). Thus, we log to the logFile only.
 				log.Logger = log.Output(zerolog.MultiLevelWriter(
-					zerolog.ConsoleWriter{Out: logFile, TimeFormat: time.RFC3339Nano, NoColor: true},
+					newLogWriter(logFile, c.String("log-format")),
 					&fleetd_logs.Logger,
 				))
 			} else {
 				log.Logger = log.Output(zerolog.MultiLevelWriter(
-					zerolog.ConsoleWriter{Out: logFile, TimeFormat: time.RFC3339Nano, NoColor: true},
-					zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano, NoColor: true},
+					newLogWriter(logFile, c.String("log-format")),
+					newLogWriter(os.Stderr, c.String("log-format")),
 					&fleetd_logs.Logger,
 				))
 			}
 		} else {
 			log.Logger = log.Output(zerolog.MultiLevelWriter(
-				zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339Nano, NoColor: true},
+				newLogWriter(os.Stderr, c.String("log-format")),
 				&fleetd_logs.Logger,
 			))
 		}
 
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-
-		if c.Bool("debug") {
-			zerolog.SetGlobalLevel(zerolog.DebugLevel)
-		}
-
 		// Override flags with values retrieved from Fleet.
 		fallbackServerOverridesCfg := setServerOverrides(c)
 		if !fallbackServerOverridesCfg.empty() {
@@ -717,7 +754,7 @@ func main() {
 				log.Info().Msg("Use custom osqueryd path for openframe mode")
 
 				osquerydPath = c.String("openframe-osquery-path")
-				if (osquerydPath == "") {
+				if osquerydPath == "" {
 					log.Fatal().Msg("openframe-osquery-path must be specified when openframe-mode is enabled")
 				}
 
@@ -752,7 +789,6 @@ func main() {
 				}
 			}
 		}
-		
 
 		// Clear leftover files from updates
 		if err := filepath.Walk(c.String("root-dir"), func(path string, info fs.FileInfo, err error) error {
@@ -1446,7 +1482,7 @@ func main() {
 		if err != nil {
 			return fmt.Errorf("create osquery runner: %w", err)
 		}
-		
+
 		// Log full command for OpenFrame mode
 		if c.Bool("openframe-mode") {
 			log.Info().
@@ -2192,144 +2228,1072 @@ func getHostInfo(osqueryPath string, osqueryDBPath string) (*osqueryHostInfo, er
 
 var versionCommand = &cli.Command{
 	Name:  "version",
-	Usage: "Get the orbit version",
-	Flags: []cli.Flag{},
-	Action: func(c *cli.Context) error {
+	Usage: "Get the orbit version (or the osquery version in OpenFrame mode)",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			// No EnvVars: hosts set ORBIT_OPENFRAME_MODE for the agent, which
+			// must not change the output of a plain `orbit version`.
+			Name:  "openframe-mode",
+			Usage: "Print the version of the OpenFrame osqueryd binary instead",
+		},
+		openframeOsqueryPathFlag(),
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output osquery version in JSON format (OpenFrame mode only)",
+		},
+		prettyFlag(),
+	},
+	Action: withExitCodes(func(c *cli.Context) error {
+		if c.Bool("openframe-mode") {
+			osquerydPath, err := locateOsqueryd(c)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			version, err := getOsquerydVersion(ctx, osquerydPath)
+			if err != nil {
+				return cli.Exit(fmt.Errorf("failed to get osquery version: %w", err), exitOsqueryFailed)
+			}
+
+			if c.Bool("json") {
+				out, err := marshalEnvelope(c, map[string]string{"osquery_version": version}, "")
+				if err != nil {
+					return fmt.Errorf("failed to marshal osquery version: %w", err)
+				}
+				fmt.Println(string(out))
+			} else {
+				fmt.Println(version)
+			}
+			return nil
+		}
+
 		fmt.Println("orbit " + build.Version)
 		fmt.Println("commit - " + build.Commit)
 		fmt.Println("date - " + build.Date)
 		return nil
-	},
+	}),
 }
 
+// Exit codes of the OpenFrame commands, which scripts can rely on to tell
+// failures apart.
+const (
+	exitOK               = 0
+	exitFailure          = 1 // invalid flags, invalid UUID, I/O errors, etc.
+	exitOsquerydNotFound = 2 // the osqueryd binary could not be located
+	exitOsqueryFailed    = 3 // osqueryd was found but the query failed
+	exitInterrupted      = 130
+)
+
+// withExitCodes returns action with any error turned into a cli.ExitCoder,
+// with the exit code of the cli.ExitCoder it wraps if any, or exitFailure.
+// main only logs errors returned by app.Run, so without this failures exit
+// with status 0 (and urfave/cli ignores wrapped cli.ExitCoder errors).
+func withExitCodes(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		err := action(c)
+		if err == nil {
+			return nil
+		}
+		code := exitFailure
+		var exitCoder cli.ExitCoder
+		if errors.As(err, &exitCoder) {
+			if err == error(exitCoder) {
+				return err
+			}
+			code = exitCoder.ExitCode()
+		}
+		return cli.Exit(err, code)
+	}
+}
+
+// withJSONErrors returns action that, if it fails while JSON output is
+// enabled as reported by jsonOutput (and --quiet is not set), also prints the
+// output envelope with the error message and nullFields set to null in the
+// data to stdout, so JSON consumers can parse failures too.
+func withJSONErrors(jsonOutput func(*cli.Context) bool, nullFields []string, action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		err := action(c)
+		if err == nil || !jsonOutput(c) || c.Bool("quiet") {
+			return err
+		}
+		var data map[string]interface{}
+		if len(nullFields) > 0 {
+			data = make(map[string]interface{}, len(nullFields))
+			for _, field := range nullFields {
+				data[field] = nil
+			}
+		}
+		out, marshalErr := marshalEnvelope(c, data, err.Error())
+		if marshalErr != nil {
+			return fmt.Errorf("%w; failed to marshal error: %w", err, marshalErr)
+		}
+		fmt.Println(string(out))
+		return err
+	}
+}
+
+// outputFormat returns the output format selected with --format (or --json),
+// which must be one of formats. The first of formats is the default.
+func outputFormat(c *cli.Context, formats ...string) (string, error) {
+	format := c.String("format")
+	if c.Bool("json") {
+		if format != "" && format != "json" {
+			return "", errors.New("json and format cannot be used together")
+		}
+		format = "json"
+	}
+	if format == "" {
+		return formats[0], nil
+	}
+	if !slices.Contains(formats, format) {
+		return "", fmt.Errorf("unsupported format %q, supported: %s", format, strings.Join(formats, ", "))
+	}
+	return format, nil
+}
+
+// isOutputFormat returns a function reporting whether the output format of the
+// command is format, ignoring invalid formats.
+func isOutputFormat(format string, formats ...string) func(*cli.Context) bool {
+	return func(c *cli.Context) bool {
+		f, err := outputFormat(c, formats...)
+		return err == nil && f == format
+	}
+}
+
+// envAssignment returns name=value as a shell variable assignment that can be
+// eval'd, quoting value if needed.
+func envAssignment(name, value string) string {
+	if value != "" && strings.IndexFunc(value, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:/@+", r))
+	}) == -1 {
+		return name + "=" + value
+	}
+	return name + "='" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// uuidFormats are the output formats of the uuid command.
+var uuidFormats = []string{"plain", "json", "env"}
+
 // Openframe command that gets host UUID from osquery database
 // TODO: move processing to openframe package
 var uuidCommand = &cli.Command{
 	Name:  "uuid",
 	Usage: "Get the host hardware UUID",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:  "json",
-			Usage: "Output UUID in JSON format",
+			Usage: "Output UUID in JSON format (same as --format json)",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Output format: plain, json or env (ORBIT_HOST_UUID=<uuid>, for eval)",
+		},
+		&cli.IntFlag{
+			Name:  "retries",
+			Usage: "Number of attempts to query osqueryd for the UUID before giving up",
+			Value: 3,
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Maximum time to wait for osqueryd to return the UUID (an attempt that times out is not retried)",
+			Value: 30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "cache-file",
+			Usage: "Path to a file used to cache the UUID; if it exists and is not empty osqueryd is not queried",
 		},
 		&cli.BoolFlag{
-			Name:    "openframe-mode",
-			Usage:   "Enable OpenFrame mode for osquery",
-			EnvVars: []string{"ORBIT_OPENFRAME_MODE"},
+			Name:  "refresh",
+			Usage: "Query osqueryd even if --cache-file exists and overwrite it with the result",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-native-fallback",
+			Usage: "Read the hardware UUID from the operating system if it cannot be obtained from osqueryd",
 		},
 		&cli.StringFlag{
-			Name:    "openframe-osquery-path",
-			Usage:   "Custom path to osqueryd binary when using OpenFrame mode",
-			EnvVars: []string{"ORBIT_OPENFRAME_OSQUERY_PATH"},
+			Name:  "output-file",
+			Usage: "Also write the output to this file, atomically replacing it",
 		},
-	},
-	Action: func(c *cli.Context) error {
-		// Set up root directory
-		rootDir := c.String("root-dir")
-		if rootDir == "" {
-			rootDir = update.DefaultOptions.RootDirectory
-			executable, err := os.Executable()
+		&cli.BoolFlag{
+			Name:  "quiet",
+			Usage: "Do not print the output to stdout (useful with --output-file)",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-invalid",
+			Usage: "Accept a host identifier that is not a well-formed UUID",
+		},
+		&cli.StringFlag{
+			Name:  "extensions-autoload",
+			Usage: "Path to an osquery extensions autoload file, for tables provided by extensions",
+		},
+		&cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "Print osqueryd warnings (its stderr output) to stderr",
+		},
+		prettyFlag(),
+		&cli.StringFlag{
+			Name:  "hash",
+			Usage: "Output the hex digest of the UUID instead of the UUID itself, using the given algorithm (supported: sha256)",
+		},
+		&cli.StringFlag{
+			Name:  "salt",
+			Usage: "Salt prepended to the UUID before hashing (requires --hash)",
+		},
+		&cli.StringFlag{
+			Name:  "temp-dir",
+			Usage: "Directory for the temporary osquery database (default: the system temporary directory)",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-temp-db",
+			Usage: "Do not remove the temporary osquery database and print its path to stderr, for debugging",
+		},
+	}, openframeFlags()...),
+	Action: withExitCodes(withJSONErrors(isOutputFormat("json", uuidFormats...), []string{"uuid"}, func(c *cli.Context) error {
+		format, err := outputFormat(c, uuidFormats...)
+		if err != nil {
+			return err
+		}
+		if c.Int("retries") < 1 {
+			return errors.New("retries must be at least 1")
+		}
+		if c.Duration("timeout") <= 0 {
+			return errors.New("timeout must be greater than zero")
+		}
+		hashAlgo := c.String("hash")
+		if hashAlgo != "" && hashAlgo != "sha256" {
+			return fmt.Errorf("unsupported hash algorithm %q, supported: sha256", hashAlgo)
+		}
+		if c.String("salt") != "" && hashAlgo == "" {
+			return errors.New("salt requires hash to be set")
+		}
+		tempDir := c.String("temp-dir")
+		if tempDir != "" {
+			if err := checkDirWritable(tempDir); err != nil {
+				return fmt.Errorf("temp-dir is not writable: %w", err)
+			}
+		}
+
+		cacheFile := c.String("cache-file")
+		var hostUUID, source string
+		if cacheFile != "" && !c.Bool("refresh") {
+			cached, err := readCachedHostUUID(cacheFile)
 			if err != nil {
-				return fmt.Errorf("failed to get orbit executable: %w", err)
+				return err
 			}
-			if strings.HasPrefix(executable, "/var/lib/orbit") {
-				rootDir = "/var/lib/orbit"
+			if cached != "" && !c.Bool("allow-invalid") {
+				if err := validateHostUUID(cached); err != nil {
+					// Query osqueryd instead, which also replaces the cached value.
+					log.Warn().Err(err).Str("cache_file", cacheFile).Msg("ignoring invalid cached host UUID")
+					cached = ""
+				}
 			}
+			hostUUID, source = cached, "cache"
 		}
 
-		var osquerydPath string
-		
-		// Check if we're using OpenFrame mode with custom osqueryd path
-		if c.Bool("openframe-mode") {
-			osquerydPath = c.String("openframe-osquery-path")
-			if osquerydPath == "" {
-				return fmt.Errorf("openframe-osquery-path must be specified when openframe-mode is enabled")
-			}
-			if _, err := os.Stat(osquerydPath); err != nil {
-				if errors.Is(err, os.ErrNotExist) {
-					return fmt.Errorf("custom openframe osqueryd binary not found: %s", osquerydPath)
-				} else {
-					return fmt.Errorf("failed to check custom openframe osqueryd binary: %w", err)
+		if hostUUID == "" {
+			// Stop osqueryd (and remove its temporary database) if interrupted.
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			exitCode := exitOsquerydNotFound
+			osquerydPath, err := locateOsqueryd(c)
+			if err == nil {
+				exitCode = exitOsqueryFailed
+				var opts []queryOption
+				if p := c.String("extensions-autoload"); p != "" {
+					opts = append(opts, withExtensionsAutoload(p))
+				}
+				if c.Bool("verbose") {
+					opts = append(opts, withStderr(os.Stderr))
+				}
+				hostUUID, err = getHostUUIDWithRetries(ctx, osquerydPath, tempDir, c.Bool("keep-temp-db"), c.Int("retries"), c.Duration("timeout"), opts...)
+				if err != nil {
+					err = fmt.Errorf("failed to get host UUID: %w", err)
 				}
 			}
-		} else {
-			// Initialize updater to get osqueryd path
-			localStore, err := filestore.New(filepath.Join(rootDir, update.MetadataFileName))
+			source = "osquery"
 			if err != nil {
-				return fmt.Errorf("failed to create local metadata store: %w", err)
+				if ctx.Err() != nil {
+					return cli.Exit("interrupted while getting host UUID", exitInterrupted)
+				}
+				if !c.Bool("allow-native-fallback") {
+					return cli.Exit(err, exitCode)
+				}
+				log.Debug().Err(err).Msg("osquery UUID query failed, falling back to native UUID")
+				nativeUUID, nativeErr := getNativeHostUUID()
+				if nativeErr != nil {
+					return cli.Exit(fmt.Errorf("%w; native UUID fallback failed: %w", err, nativeErr), exitCode)
+				}
+				hostUUID, source = nativeUUID, "native"
 			}
 
-			opt := update.DefaultOptions
-			opt.RootDirectory = rootDir
-			opt.LocalStore = localStore
-			
-			updater := update.NewDisabled(opt)
-			osquerydPath, err = updater.ExecutableLocalPath(constant.OsqueryTUFTargetName)
+			if !c.Bool("allow-invalid") {
+				if err := validateHostUUID(hostUUID); err != nil {
+					return fmt.Errorf("%w (source: %s, use --allow-invalid to accept it)", err, source)
+				}
+			}
+
+			if cacheFile != "" {
+				if err := writeFileAtomic(cacheFile, []byte(hostUUID), constant.DefaultFileMode); err != nil {
+					return fmt.Errorf("failed to write UUID cache file: %w", err)
+				}
+			}
+		}
+
+		out := hostUUID
+		output := map[string]string{"uuid": hostUUID, "source": source}
+		envName := "ORBIT_HOST_UUID"
+		if hashAlgo != "" {
+			out = hashHostUUID(hostUUID, c.String("salt"))
+			output = map[string]string{"uuid_hash": out, "algorithm": hashAlgo, "source": source}
+			envName = "ORBIT_HOST_UUID_HASH"
+		}
+		switch format {
+		case "json":
+			b, err := marshalEnvelope(c, output, "")
 			if err != nil {
-				return fmt.Errorf("failed to locate osqueryd: %w", err)
+				return fmt.Errorf("failed to marshal UUID: %w", err)
+			}
+			out = string(b)
+		case "env":
+			out = envAssignment(envName, out)
+		}
+		if outputFile := c.String("output-file"); outputFile != "" {
+			if err := writeFileAtomic(outputFile, []byte(out+"\n"), constant.DefaultWorldReadableFileMode); err != nil {
+				return fmt.Errorf("failed to write output file: %w", err)
 			}
 		}
+		if !c.Bool("quiet") {
+			fmt.Println(out)
+		}
+		return nil
+	})),
+}
 
-		// Use temporary database for UUID query
-		tmpDBPath := filepath.Join(os.TempDir(), fmt.Sprintf("orbit-uuid-%s", uuid.NewString()))
-		defer os.RemoveAll(tmpDBPath)
+// Openframe command that gets host hardware serial number from osquery database
+var serialCommand = &cli.Command{
+	Name:  "serial",
+	Usage: "Get the host hardware serial number",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output serial number in JSON format",
+		},
+		prettyFlag(),
+	}, openframeFlags()...),
+	Action: withExitCodes(withJSONErrors(func(c *cli.Context) bool { return c.Bool("json") }, []string{"hardware_serial"}, func(c *cli.Context) error {
+		osquerydPath, err := locateOsqueryd(c)
+		if err != nil {
+			return err
+		}
 
-		hostUUID, err := getHostUUID(osquerydPath, tmpDBPath)
+		tmpDBPath, removeDB := newTempDB("", "serial")
+		defer removeDB()
+
+		serial, err := getHostSerial(context.Background(), osquerydPath, tmpDBPath)
 		if err != nil {
-			return fmt.Errorf("failed to get host UUID: %w", err)
+			return cli.Exit(fmt.Errorf("failed to get host serial: %w", err), exitOsqueryFailed)
 		}
 
 		if c.Bool("json") {
-			fmt.Printf("{\"uuid\":\"%s\"}\n", hostUUID)
+			out, err := marshalEnvelope(c, map[string]string{"hardware_serial": serial}, "")
+			if err != nil {
+				return fmt.Errorf("failed to marshal serial: %w", err)
+			}
+			fmt.Println(string(out))
 		} else {
-			fmt.Println(hostUUID)
+			fmt.Println(serial)
+		}
+		return nil
+	})),
+}
+
+// Openframe command that gets all the host identity fields from osquery database
+// with a single osqueryd run, so all values come from the same snapshot.
+var identityCommand = &cli.Command{
+	Name:  "identity",
+	Usage: "Get the host UUID, hardware serial number, hostname and computer name as JSON",
+	Flags: append([]cli.Flag{
+		prettyFlag(),
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Output format: json, or env for one ORBIT_HOST_<COLUMN>=<value> line per column (for eval)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "columns",
+			Usage: "Comma-separated system_info columns to output (default: " + strings.Join(defaultIdentityColumns, ",") + ")",
+		},
+	}, openframeFlags()...),
+	Action: withExitCodes(withJSONErrors(isOutputFormat("json", "json", "env"), nil, func(c *cli.Context) error {
+		format, err := outputFormat(c, "json", "env")
+		if err != nil {
+			return err
+		}
+
+		columns := defaultIdentityColumns
+		if c.IsSet("columns") {
+			columns = c.StringSlice("columns")
+			if err := validateIdentityColumns(columns); err != nil {
+				return err
+			}
+		}
+
+		osquerydPath, err := locateOsqueryd(c)
+		if err != nil {
+			return err
+		}
+
+		tmpDBPath, removeDB := newTempDB("", "identity")
+		defer removeDB()
+
+		identity, err := getHostIdentity(context.Background(), osquerydPath, tmpDBPath, columns)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("failed to get host identity: %w", err), exitOsqueryFailed)
+		}
+
+		if format == "env" {
+			for _, column := range columns {
+				fmt.Println(envAssignment("ORBIT_HOST_"+strings.ToUpper(column), identity[column]))
+			}
+			return nil
 		}
+
+		out, err := marshalEnvelope(c, identity, "")
+		if err != nil {
+			return fmt.Errorf("failed to marshal host identity: %w", err)
+		}
+		fmt.Println(string(out))
 		return nil
+	})),
+}
+
+// newLogWriter returns the zerolog writer for the given --log-format: a
+// console writer, or w itself for JSON lines.
+func newLogWriter(w io.Writer, format string) io.Writer {
+	if format == "json" {
+		return w
+	}
+	return zerolog.ConsoleWriter{Out: w, TimeFormat: time.RFC3339Nano, NoColor: true}
+}
+
+// outputSchemaVersion is the version of the envelope of the JSON output of
+// the OpenFrame commands. It must be increased on incompatible changes to the
+// data of any command.
+const outputSchemaVersion = 1
+
+// outputEnvelope wraps the JSON output of the OpenFrame commands so consumers
+// can detect format changes.
+type outputEnvelope struct {
+	SchemaVersion int         `json:"schema_version"`
+	Command       string      `json:"command"`
+	Data          interface{} `json:"data"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// marshalEnvelope returns the JSON output of the command of c with data (and
+// errMsg if the command failed), indented if --pretty is set.
+func marshalEnvelope(c *cli.Context, data interface{}, errMsg string) ([]byte, error) {
+	return marshalOutput(outputEnvelope{
+		SchemaVersion: outputSchemaVersion,
+		Command:       c.Command.Name,
+		Data:          data,
+		Error:         errMsg,
+	}, c.Bool("pretty"))
+}
+
+// marshalOutput returns the JSON encoding of v used as command output,
+// indented if pretty is set.
+func marshalOutput(v interface{}, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(v, "", "  ")
+	}
+	return json.Marshal(v)
+}
+
+// resolveRootDir returns the default root directory for Orbit state, used when
+// --root-dir is not set.
+func resolveRootDir() (string, error) {
+	// handle old installations, which had default root dir set to /var/lib/orbit
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get orbit executable: %w", err)
+	}
+	if strings.HasPrefix(executable, "/var/lib/orbit") {
+		return "/var/lib/orbit", nil
+	}
+	return update.DefaultOptions.RootDirectory, nil
+}
+
+// openframeFlags returns the flags of the OpenFrame commands read by
+// locateOsqueryd.
+func openframeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "openframe-mode",
+			Usage:   "Enable OpenFrame mode for osquery",
+			EnvVars: []string{"ORBIT_OPENFRAME_MODE"},
+		},
+		openframeOsqueryPathFlag(),
+	}
+}
+
+func openframeOsqueryPathFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "openframe-osquery-path",
+		Usage:   "Custom path to osqueryd binary when using OpenFrame mode",
+		EnvVars: []string{"ORBIT_OPENFRAME_OSQUERY_PATH"},
+	}
+}
+
+// prettyFlag returns the --pretty flag of the commands with JSON output, read
+// by marshalEnvelope.
+func prettyFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "pretty",
+		Usage: "Indent JSON output",
+	}
+}
+
+// osquerydPathFileName is the name of the file in the root directory that
+// OpenFrame installs can use to record the path of the osqueryd binary.
+const osquerydPathFileName = "osquery-path"
+
+// locateOsqueryd returns the path of the osqueryd binary to use for the
+// OpenFrame identity commands. In OpenFrame mode the custom path provided
+// via --openframe-osquery-path is used; if not set, the path recorded in the
+// osquery-path file of the root directory, the osqueryd TUF target or the
+// first osqueryd found in the common install locations, in that order.
+// Otherwise the path of the osqueryd TUF target under the root directory.
+// Failures exit with exitOsquerydNotFound.
+func locateOsqueryd(c *cli.Context) (string, error) {
+	osquerydPath, err := findOsqueryd(c)
+	if err != nil {
+		return "", cli.Exit(err, exitOsquerydNotFound)
+	}
+	return osquerydPath, nil
+}
+
+// findOsqueryd implements locateOsqueryd.
+func findOsqueryd(c *cli.Context) (string, error) {
+	// Set up root directory
+	rootDir := c.String("root-dir")
+	if rootDir == "" {
+		var err error
+		rootDir, err = resolveRootDir()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Check if we're using OpenFrame mode with custom osqueryd path
+	if c.Bool("openframe-mode") {
+		osquerydPath := c.String("openframe-osquery-path")
+		if osquerydPath == "" {
+			p, err := readOsquerydPathFile(rootDir)
+			if err != nil {
+				return "", err
+			}
+			if p != "" {
+				log.Debug().Str("path", p).Msg("using osqueryd binary from " + osquerydPathFileName)
+				return p, nil
+			}
+			if p, err := tufOsquerydPath(rootDir); err == nil {
+				log.Debug().Str("path", p).Msg("using osqueryd TUF target")
+				return p, nil
+			}
+			p, err = discoverOsqueryd()
+			if err != nil {
+				return "", fmt.Errorf("openframe-osquery-path not specified and %w", err)
+			}
+			log.Debug().Str("path", p).Msg("using discovered osqueryd binary")
+			return p, nil
+		}
+		if _, err := os.Stat(osquerydPath); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return "", fmt.Errorf("custom openframe osqueryd binary not found: %s", osquerydPath)
+			}
+			return "", fmt.Errorf("failed to check custom openframe osqueryd binary: %w", err)
+		}
+		return osquerydPath, nil
+	}
+
+	osquerydPath, err := tufOsquerydPath(rootDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate osqueryd: %w", err)
+	}
+	return osquerydPath, nil
+}
+
+// readOsquerydPathFile returns the osqueryd path recorded in the osquery-path
+// file of rootDir, or an empty string if the file doesn't exist or is empty.
+func readOsquerydPathFile(rootDir string) (string, error) {
+	pathFile := filepath.Join(rootDir, osquerydPathFileName)
+	b, err := os.ReadFile(pathFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read %s: %w", pathFile, err)
+	}
+	p := strings.TrimSpace(string(b))
+	if p == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(p); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("osqueryd binary from %s not found: %s", pathFile, p)
+		}
+		return "", fmt.Errorf("failed to check osqueryd binary from %s: %w", pathFile, err)
+	}
+	return p, nil
+}
+
+// tufOsquerydPath returns the path of the osqueryd TUF target under rootDir,
+// if it exists.
+func tufOsquerydPath(rootDir string) (string, error) {
+	// Initialize updater to get osqueryd path
+	localStore, err := filestore.New(filepath.Join(rootDir, update.MetadataFileName))
+	if err != nil {
+		return "", fmt.Errorf("failed to create local metadata store: %w", err)
+	}
+
+	opt := update.DefaultOptions
+	opt.RootDirectory = rootDir
+	opt.LocalStore = localStore
+
+	updater := update.NewDisabled(opt)
+	return updater.ExecutableLocalPath(constant.OsqueryTUFTargetName)
+}
+
+// osquerydSearchPaths are the common osqueryd install locations, per platform,
+// searched when no osqueryd path is provided in OpenFrame mode.
+var osquerydSearchPaths = map[string][]string{
+	"darwin": {
+		"/opt/orbit/bin/osqueryd/osqueryd",
+		"/opt/osquery/lib/osquery.app/Contents/MacOS/osqueryd",
+		"/usr/local/bin/osqueryd",
+	},
+	"linux": {
+		"/opt/orbit/bin/osqueryd/osqueryd",
+		"/opt/osquery/bin/osqueryd",
+		"/usr/bin/osqueryd",
+		"/usr/local/bin/osqueryd",
+	},
+	"windows": {
+		`C:\Program Files\Orbit\bin\osqueryd\osqueryd.exe`,
+		`C:\Program Files\osquery\osqueryd\osqueryd.exe`,
+		`C:\Program Files\osquery\osqueryd.exe`,
 	},
 }
 
-func getHostUUID(osqueryPath string, osqueryDBPath string) (string, error) {
-	// Make sure parent directory exists (`osqueryd -S` doesn't create the parent directories).
-	if err := os.MkdirAll(filepath.Dir(osqueryDBPath), constant.DefaultDirMode); err != nil {
+// discoverOsqueryd returns the first executable osqueryd found in the common
+// install locations for the current platform or in the PATH.
+func discoverOsqueryd() (string, error) {
+	for _, p := range osquerydSearchPaths[runtime.GOOS] {
+		if err := checkExecutable(p); err == nil {
+			return p, nil
+		}
+	}
+	p, err := exec.LookPath(constant.OsqueryTUFTargetName)
+	if err != nil {
+		return "", errors.New("osqueryd was not found in the common install locations or in the PATH")
+	}
+	return p, nil
+}
+
+func getHostUUID(ctx context.Context, osquerydPath string, dbPath string, opts ...queryOption) (string, error) {
+	v, err := querySingleValue(ctx, osquerydPath, dbPath, `SELECT uuid FROM system_info`, "uuid", opts...)
+	if err != nil {
+		return "", checkSystemInfoTable(ctx, osquerydPath, dbPath, err, opts...)
+	}
+	return v, nil
+}
+
+// errSystemInfoUnavailable is returned by the system_info queries when the
+// osqueryd build doesn't provide the table.
+var errSystemInfoUnavailable = errors.New("system_info table unavailable in this osquery build")
+
+// checkSystemInfoTable is called when a system_info query failed with err. It
+// returns errSystemInfoUnavailable if the table doesn't exist in the osqueryd
+// build (which otherwise surfaces as a confusing "no such table" or "expected 1
+// row" error), and err otherwise.
+func checkSystemInfoTable(ctx context.Context, osquerydPath, dbPath string, err error, opts ...queryOption) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	if strings.Contains(err.Error(), "no such table: system_info") {
+		return errSystemInfoUnavailable
+	}
+	rows, registryErr := runOsqueryQuery(ctx, osquerydPath, dbPath,
+		`SELECT name FROM osquery_registry WHERE registry = 'table' AND name = 'system_info'`, opts...)
+	if registryErr == nil && len(rows) == 0 {
+		return errSystemInfoUnavailable
+	}
+	return err
+}
+
+// validateHostUUID returns an error if v is not a well-formed UUID, which can
+// happen on some virtualized hardware.
+func validateHostUUID(v string) error {
+	if _, err := uuid.Parse(v); err != nil {
+		return fmt.Errorf("invalid host UUID %q: %w", v, err)
+	}
+	return nil
+}
+
+// hashHostUUID returns the hex encoded SHA-256 digest of the salted UUID, so
+// hosts can be correlated without exposing the hardware identifier.
+func hashHostUUID(hostUUID, salt string) string {
+	sum := sha256.Sum256([]byte(salt + hostUUID))
+	return hex.EncodeToString(sum[:])
+}
+
+// readCachedHostUUID returns the UUID stored in cacheFile, or an empty string
+// if the file doesn't exist or is empty.
+func readCachedHostUUID(cacheFile string) (string, error) {
+	b, err := os.ReadFile(cacheFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read UUID cache file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path and
+// renames it to path, so readers never see a partially written file. The parent
+// directory is created if it doesn't exist.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, constant.DefaultDirMode); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// newTempDB returns the path of a new temporary osquery database named after
+// the command, in dir (or the system temporary directory if dir is empty), and
+// a function that removes it.
+func newTempDB(dir, command string) (string, func()) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	dbPath := filepath.Join(dir, fmt.Sprintf("orbit-%s-%s", command, uuid.NewString()))
+	return dbPath, func() { os.RemoveAll(dbPath) }
+}
+
+// getHostUUIDWithRetries queries osqueryd for the host UUID up to attempts
+// times with an exponential backoff between attempts. Each attempt uses a fresh
+// temporary database, because a cold osqueryd run can fail transiently (e.g.
+// exit status 78 or a lock on the database path). Each attempt is killed if it
+// takes longer than timeout, and no more attempts are made after an attempt
+// timed out (a hung osqueryd would likely hang again) or once ctx is done.
+// The temporary databases are created in tempDir, or in the system temporary
+// directory if tempDir is empty, and removed after each attempt unless keepDB
+// is set (their paths are then printed to stderr for inspection).
+func getHostUUIDWithRetries(ctx context.Context, osquerydPath string, tempDir string, keepDB bool, attempts int, timeout time.Duration, opts ...queryOption) (string, error) {
+	var (
+		hostUUID string
+		attempt  int
+		timedOut bool
+	)
+	if err := retrypkg.Do(func() error {
+		attempt++
+
+		tmpDBPath, removeDB := newTempDB(tempDir, "uuid")
+		if keepDB {
+			defer fmt.Fprintf(os.Stderr, "kept temporary osquery database: %s\n", tmpDBPath)
+		} else {
+			defer removeDB()
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		var err error
+		hostUUID, err = getHostUUID(attemptCtx, osquerydPath, tmpDBPath, opts...)
+		if errors.Is(err, context.DeadlineExceeded) {
+			timedOut = true
+			err = fmt.Errorf("osquery query timed out after %s", timeout)
+		}
+		if err != nil {
+			log.Debug().Err(err).Int("attempt", attempt).Msg("host UUID query failed")
+		}
+		return err
+	},
+		retrypkg.WithContext(ctx),
+		retrypkg.WithMaxAttempts(attempts),
+		retrypkg.WithInterval(1*time.Second),
+		retrypkg.WithBackoffMultiplier(2),
+		retrypkg.WithErrorFilter(func(error) retrypkg.ErrorOutcome {
+			if timedOut || ctx.Err() != nil {
+				return retrypkg.ErrorOutcomeDoNotRetry
+			}
+			return retrypkg.ErrorOutcomeNormalRetry
+		}),
+	); err != nil {
+		return "", fmt.Errorf("after %d attempt(s): %w", attempt, err)
+	}
+	return hostUUID, nil
+}
+
+// getOsquerydVersion runs `osqueryd --version` and returns the version number
+// from its output (e.g. "5.12.1" from "osqueryd version 5.12.1").
+func getOsquerydVersion(ctx context.Context, osquerydPath string) (string, error) {
+	cmd := exec.CommandContext(ctx, osquerydPath, "--version")
+	cmd.WaitDelay = osquerydWaitDelay
+	var (
+		osquerydStdout bytes.Buffer
+		osquerydStderr bytes.Buffer
+	)
+	cmd.Stdout = &osquerydStdout
+	cmd.Stderr = &osquerydStderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("osqueryd did not complete: %w", ctx.Err())
+		}
+		return "", fmt.Errorf("osqueryd failed: %w, output: %s, stderr: %s", err, osquerydStdout.String(), osquerydStderr.String())
+	}
+	return parseOsquerydVersion(osquerydStdout.String())
+}
+
+// parseOsquerydVersion extracts the version number from the output of
+// `osqueryd --version`.
+func parseOsquerydVersion(output string) (string, error) {
+	fields := strings.Fields(output)
+	for i, field := range fields {
+		if field == "version" && i+1 < len(fields) {
+			return fields[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("unexpected osqueryd version output: %q", strings.TrimSpace(output))
+}
+
+func getHostSerial(ctx context.Context, osquerydPath string, dbPath string, opts ...queryOption) (string, error) {
+	v, err := querySingleValue(ctx, osquerydPath, dbPath, `SELECT hardware_serial FROM system_info`, "hardware_serial", opts...)
+	if err != nil {
+		return "", checkSystemInfoTable(ctx, osquerydPath, dbPath, err, opts...)
+	}
+	return v, nil
+}
+
+// defaultIdentityColumns are the system_info columns output by the identity
+// command when --columns is not set.
+var defaultIdentityColumns = []string{"uuid", "hardware_serial", "hostname", "computer_name"}
+
+// identityColumns are the system_info columns that can be requested with
+// --columns. The column names are used to build the query, so only these are
+// accepted.
+var identityColumns = []string{
+	"board_model",
+	"board_serial",
+	"board_vendor",
+	"board_version",
+	"computer_name",
+	"cpu_brand",
+	"cpu_logical_cores",
+	"cpu_physical_cores",
+	"cpu_subtype",
+	"cpu_type",
+	"hardware_model",
+	"hardware_serial",
+	"hardware_vendor",
+	"hardware_version",
+	"hostname",
+	"local_hostname",
+	"physical_memory",
+	"uuid",
+}
+
+// validateIdentityColumns returns an error if columns is empty, contains a
+// column that is not in identityColumns or contains a column more than once.
+func validateIdentityColumns(columns []string) error {
+	if len(columns) == 0 {
+		return errors.New("at least one column must be provided")
+	}
+	for i, column := range columns {
+		if !slices.Contains(identityColumns, column) {
+			return fmt.Errorf("unknown column %q, allowed columns: %s", column, strings.Join(identityColumns, ", "))
+		}
+		if slices.Contains(columns[:i], column) {
+			return fmt.Errorf("duplicate column %q", column)
+		}
+	}
+	return nil
+}
+
+// getHostIdentity returns the given (validated) columns of system_info, keyed
+// by column name.
+func getHostIdentity(ctx context.Context, osquerydPath string, dbPath string, columns []string, opts ...queryOption) (map[string]string, error) {
+	row, err := querySingleRow(ctx, osquerydPath, dbPath, "SELECT "+strings.Join(columns, ", ")+" FROM system_info", opts...)
+	if err != nil {
+		return nil, checkSystemInfoTable(ctx, osquerydPath, dbPath, err, opts...)
+	}
+	identity := make(map[string]string, len(columns))
+	for _, column := range columns {
+		value, ok := row[column].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s field not found or not a string", column)
+		}
+		identity[column] = value
+	}
+	return identity, nil
+}
+
+// querySingleValue runs sql with osqueryd in single query mode using the
+// provided (temporary) database path, and returns the string value of column
+// from the single row returned.
+func querySingleValue(ctx context.Context, osquerydPath, dbPath, sql, column string, opts ...queryOption) (string, error) {
+	row, err := querySingleRow(ctx, osquerydPath, dbPath, sql, opts...)
+	if err != nil {
 		return "", err
 	}
-	const uuidQuery = `SELECT uuid FROM system_info`
+
+	value, ok := row[column].(string)
+	if !ok {
+		return "", fmt.Errorf("%s field not found or not a string", column)
+	}
+
+	return value, nil
+}
+
+// queryConfig holds the optional settings of runOsqueryQuery.
+type queryConfig struct {
+	extensionsAutoload string
+	stderr             io.Writer
+}
+
+// queryOption allows to configure how runOsqueryQuery runs osqueryd.
+type queryOption func(*queryConfig)
+
+// withExtensionsAutoload loads the osquery extensions listed in the given
+// autoload file, for deployments that rely on extension-provided tables.
+func withExtensionsAutoload(path string) queryOption {
+	return func(c *queryConfig) {
+		c.extensionsAutoload = path
+	}
+}
+
+// withStderr writes the stderr output of osqueryd to w when the query
+// succeeds, e.g. to surface deprecation or permission warnings. (On failure the
+// stderr output is always included in the returned error.)
+func withStderr(w io.Writer) queryOption {
+	return func(c *queryConfig) {
+		c.stderr = w
+	}
+}
+
+// querySingleRow runs sql with osqueryd in single query mode using the
+// provided (temporary) database path, and returns the single row returned.
+func querySingleRow(ctx context.Context, osquerydPath, dbPath, sql string, opts ...queryOption) (map[string]interface{}, error) {
+	result, err := runOsqueryQuery(ctx, osquerydPath, dbPath, sql, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result) != 1 {
+		return nil, fmt.Errorf("expected 1 row from query, got %d", len(result))
+	}
+
+	return result[0], nil
+}
+
+// osquerydExitShutdownRequested is the exit status of osqueryd when it shuts
+// down on request, which in single query mode can happen after the results
+// were printed.
+const osquerydExitShutdownRequested = 78
+
+// osquerydWaitDelay is how long to wait for the output pipes of osqueryd to be
+// closed after it was killed because its context is done. Children of osqueryd
+// (e.g. autoloaded extensions) can inherit the pipes and would otherwise keep
+// the command from returning.
+var osquerydWaitDelay = 3 * time.Second
+
+// runOsqueryQuery runs sql with osqueryd in single query mode using the
+// provided (temporary) database path, and returns all the rows returned.
+// The osqueryd process is killed if ctx is done before it exits.
+func runOsqueryQuery(ctx context.Context, osquerydPath, dbPath, sql string, opts ...queryOption) ([]map[string]interface{}, error) {
+	var cfg queryConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// Make sure parent directory exists (`osqueryd -S` doesn't create the parent directories).
+	if err := os.MkdirAll(filepath.Dir(dbPath), constant.DefaultDirMode); err != nil {
+		return nil, err
+	}
 	args := []string{
 		"-S",
-		"--database_path", osqueryDBPath,
-		"--json", uuidQuery,
+		"--database_path", dbPath,
 	}
-	cmd := exec.Command(osqueryPath, args...)
+	if cfg.extensionsAutoload != "" {
+		// Use a socket unique to this database so concurrent runs (and the
+		// orbit daemon's osqueryd) don't collide.
+		socketPath := dbPath + ".em"
+		if runtime.GOOS == "windows" {
+			socketPath = `\\.\pipe\` + filepath.Base(dbPath)
+		} else {
+			defer os.Remove(socketPath)
+		}
+		args = append(args,
+			"--extensions_autoload="+cfg.extensionsAutoload,
+			"--extensions_socket="+socketPath,
+		)
+	}
+	args = append(args, "--json", sql)
+	cmd := exec.CommandContext(ctx, osquerydPath, args...)
+	cmd.WaitDelay = osquerydWaitDelay
 	var (
 		osquerydStdout bytes.Buffer
 		osquerydStderr bytes.Buffer
 	)
 	cmd.Stdout = &osquerydStdout
 	cmd.Stderr = &osquerydStderr
-	
+
 	var result []map[string]interface{}
 	if err := cmd.Run(); err != nil {
-		// Try to unmarshal the result even if there's an error (osquery exit status 78 issue)
-		unmarshalErr := json.Unmarshal(osquerydStdout.Bytes(), &result)
-		if unmarshalErr != nil {
-			return "", fmt.Errorf("osqueryd failed: %w, output: %s, stderr: %s", err, osquerydStdout.String(), osquerydStderr.String())
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("osqueryd did not complete: %w", ctx.Err())
+		}
+		// osqueryd can exit with status 78 (shutdown requested) after printing
+		// the results, so only then the output is still parsed.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != osquerydExitShutdownRequested {
+			return nil, fmt.Errorf("osqueryd failed: %w, output: %s, stderr: %s", err, osquerydStdout.String(), osquerydStderr.String())
+		}
+		if unmarshalErr := json.Unmarshal(osquerydStdout.Bytes(), &result); unmarshalErr != nil {
+			return nil, fmt.Errorf("osqueryd failed: %w, output: %s, stderr: %s", err, osquerydStdout.String(), osquerydStderr.String())
 		}
 	} else {
 		if err := json.Unmarshal(osquerydStdout.Bytes(), &result); err != nil {
-			return "", fmt.Errorf("failed to parse osqueryd output: %w", err)
+			return nil, fmt.Errorf("failed to parse osqueryd output: %w", err)
 		}
 	}
-	
-	if len(result) != 1 {
-		return "", fmt.Errorf("expected 1 row from UUID query, got %d", len(result))
-	}
-	
-	uuid, ok := result[0]["uuid"].(string)
-	if !ok {
-		return "", fmt.Errorf("UUID field not found or not a string")
+	if cfg.stderr != nil && osquerydStderr.Len() > 0 {
+		if _, err := osquerydStderr.WriteTo(cfg.stderr); err != nil {
+			return nil, fmt.Errorf("failed to write osqueryd stderr: %w", err)
+		}
 	}
-	
-	return uuid, nil
+	return result, nil
 }
 
 // serviceChecker is a helper to gracefully shutdown the runners group when a
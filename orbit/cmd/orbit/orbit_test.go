@@ -1,11 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
 )
 
 func TestCfgsDiffer(t *testing.T) {
@@ -163,3 +176,617 @@ func TestProcessLog(t *testing.T) {
 	msg = <-runner.errorNotifyCh
 	assert.Equal(t, string(logErrorMissingExecMsg), msg)
 }
+
+// writeFakeOsqueryd writes a shell script that mimics `osqueryd -S --json`
+// by printing stdout and exiting with exitCode.
+func writeFakeOsqueryd(t *testing.T, stdout string, exitCode int) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake osqueryd script is not supported on Windows")
+	}
+	p := filepath.Join(t.TempDir(), "osqueryd")
+	script := fmt.Sprintf("#!/bin/sh\ncat <<'EOF'\n%s\nEOF\nexit %d\n", stdout, exitCode)
+	require.NoError(t, os.WriteFile(p, []byte(script), 0o755))
+	return p
+}
+
+func TestQuerySingleValue(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		stdout   string
+		exitCode int
+		expected string
+		errMsg   string
+	}{
+		{
+			name:     "single row",
+			stdout:   `[{"hardware_serial":"C02ABC123"}]`,
+			expected: "C02ABC123",
+		},
+		{
+			name:     "exit status 78 with valid output",
+			stdout:   `[{"hardware_serial":"C02ABC123"}]`,
+			exitCode: 78,
+			expected: "C02ABC123",
+		},
+		{
+			name:     "valid output with other exit status",
+			stdout:   `[{"hardware_serial":"C02ABC123"}]`,
+			exitCode: 1,
+			errMsg:   "osqueryd failed: exit status 1",
+		},
+		{
+			name:     "invalid output on exit status 78",
+			stdout:   `boom`,
+			exitCode: 78,
+			errMsg:   "osqueryd failed: exit status 78",
+		},
+		{
+			name:     "invalid output on error",
+			stdout:   `boom`,
+			exitCode: 1,
+			errMsg:   "osqueryd failed",
+		},
+		{
+			name:   "no rows",
+			stdout: `[]`,
+			errMsg: "expected 1 row from query, got 0",
+		},
+		{
+			name:   "missing column",
+			stdout: `[{"uuid":"foo"}]`,
+			errMsg: "hardware_serial field not found or not a string",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			osquerydPath := writeFakeOsqueryd(t, tc.stdout, tc.exitCode)
+			v, err := querySingleValue(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"), `SELECT hardware_serial FROM system_info`, "hardware_serial")
+			if tc.errMsg != "" {
+				require.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, v)
+		})
+	}
+}
+
+func TestRunOsqueryQuery(t *testing.T) {
+	osquerydPath := writeFakeOsqueryd(t, `[{"name":"a"},{"name":"b"}]`, 0)
+	rows, err := runOsqueryQuery(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"), `SELECT name FROM t`)
+	require.NoError(t, err)
+	require.Equal(t, []map[string]interface{}{{"name": "a"}, {"name": "b"}}, rows)
+
+	slowPath := filepath.Join(t.TempDir(), "osqueryd")
+	require.NoError(t, os.WriteFile(slowPath, []byte("#!/bin/sh\nexec sleep 10\n"), 0o755))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = runOsqueryQuery(ctx, slowPath, filepath.Join(t.TempDir(), "db"), `SELECT 1`)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRunOsqueryQueryChildHoldsPipes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake osqueryd script is not supported on Windows")
+	}
+	waitDelay := osquerydWaitDelay
+	osquerydWaitDelay = 100 * time.Millisecond
+	t.Cleanup(func() { osquerydWaitDelay = waitDelay })
+
+	// The background sleep inherits stdout and stderr and outlives the killed
+	// osqueryd, like an autoloaded extension would.
+	osquerydPath := filepath.Join(t.TempDir(), "osqueryd")
+	require.NoError(t, os.WriteFile(osquerydPath, []byte("#!/bin/sh\nsleep 10 &\nexec sleep 10\n"), 0o755))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := runOsqueryQuery(ctx, osquerydPath, filepath.Join(t.TempDir(), "db"), `SELECT 1`)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestGetHostUUIDWithRetries(t *testing.T) {
+	osquerydPath := writeFakeOsqueryd(t, `[{"uuid":"6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90"}]`, 0)
+	v, err := getHostUUIDWithRetries(context.Background(), osquerydPath, "", false, 3, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90", v)
+
+	tempDir := t.TempDir()
+	v, err = getHostUUIDWithRetries(context.Background(), osquerydPath, tempDir, false, 1, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90", v)
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// With keepDB the database created by osqueryd is left in place.
+	creatingPath := filepath.Join(t.TempDir(), "osqueryd")
+	require.NoError(t, os.WriteFile(creatingPath, []byte("#!/bin/sh\nmkdir -p \"$3\"\necho '[{\"uuid\":\"6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90\"}]'\n"), 0o755))
+	keepDir := t.TempDir()
+	_, err = getHostUUIDWithRetries(context.Background(), creatingPath, keepDir, true, 1, 5*time.Second)
+	require.NoError(t, err)
+	entries, err = os.ReadDir(keepDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	osquerydPath = writeFakeOsqueryd(t, `boom`, 1)
+	_, err = getHostUUIDWithRetries(context.Background(), osquerydPath, "", false, 2, 5*time.Second)
+	require.ErrorContains(t, err, "after 2 attempt(s)")
+
+	slowPath := filepath.Join(t.TempDir(), "osqueryd")
+	require.NoError(t, os.WriteFile(slowPath, []byte("#!/bin/sh\nexec sleep 10\n"), 0o755))
+	_, err = getHostUUIDWithRetries(context.Background(), slowPath, "", false, 1, 100*time.Millisecond)
+	require.ErrorContains(t, err, "osquery query timed out after 100ms")
+
+	// A timed out attempt is not retried.
+	start := time.Now()
+	_, err = getHostUUIDWithRetries(context.Background(), slowPath, "", false, 3, 100*time.Millisecond)
+	require.ErrorContains(t, err, "after 1 attempt(s): osquery query timed out after 100ms")
+	require.Less(t, time.Since(start), 900*time.Millisecond)
+
+	// An interrupted run kills osqueryd and doesn't retry.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	_, err = getHostUUIDWithRetries(ctx, slowPath, tempDir, false, 3, 5*time.Second)
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorContains(t, err, "after 1 attempt(s)")
+	require.Less(t, time.Since(start), 5*time.Second)
+	entries, err = os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// An interruption during the backoff between attempts returns right away.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start = time.Now()
+	_, err = getHostUUIDWithRetries(ctx, osquerydPath, "", false, 5, 5*time.Second)
+	require.ErrorContains(t, err, "after 1 attempt(s)")
+	require.Less(t, time.Since(start), 900*time.Millisecond)
+}
+
+func TestReadCachedHostUUID(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "uuid")
+
+	v, err := readCachedHostUUID(cacheFile)
+	require.NoError(t, err)
+	require.Empty(t, v)
+
+	require.NoError(t, os.WriteFile(cacheFile, []byte("  \n"), 0o600))
+	v, err = readCachedHostUUID(cacheFile)
+	require.NoError(t, err)
+	require.Empty(t, v)
+
+	require.NoError(t, os.WriteFile(cacheFile, []byte("6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90\n"), 0o600))
+	v, err = readCachedHostUUID(cacheFile)
+	require.NoError(t, err)
+	require.Equal(t, "6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90", v)
+}
+
+func TestUUIDCommandInvalidCache(t *testing.T) {
+	const hostUUID = "6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90"
+	osquerydPath := writeFakeOsqueryd(t, `[{"uuid":"`+hostUUID+`"}]`, 0)
+
+	runUUID := func(t *testing.T, args ...string) string {
+		output := filepath.Join(t.TempDir(), "uuid.txt")
+		app := cli.NewApp()
+		app.Flags = []cli.Flag{&cli.StringFlag{Name: "root-dir"}}
+		app.Commands = []*cli.Command{uuidCommand}
+		require.NoError(t, app.Run(append([]string{
+			"orbit", "--root-dir", t.TempDir(), "uuid", "--quiet", "--output-file", output,
+			"--openframe-mode", "--openframe-osquery-path", osquerydPath,
+		}, args...)))
+		b, err := os.ReadFile(output)
+		require.NoError(t, err)
+		return strings.TrimSpace(string(b))
+	}
+
+	t.Run("invalid cache is ignored and replaced", func(t *testing.T) {
+		cacheFile := filepath.Join(t.TempDir(), "uuid")
+		require.NoError(t, os.WriteFile(cacheFile, []byte("garbage"), 0o600))
+		require.Equal(t, hostUUID, runUUID(t, "--cache-file", cacheFile))
+		cached, err := readCachedHostUUID(cacheFile)
+		require.NoError(t, err)
+		require.Equal(t, hostUUID, cached)
+	})
+
+	t.Run("invalid cache is used with allow-invalid", func(t *testing.T) {
+		cacheFile := filepath.Join(t.TempDir(), "uuid")
+		require.NoError(t, os.WriteFile(cacheFile, []byte("Not Settable"), 0o600))
+		require.Equal(t, "Not Settable", runUUID(t, "--cache-file", cacheFile, "--allow-invalid"))
+	})
+}
+
+func TestGetHostIdentity(t *testing.T) {
+	osquerydPath := writeFakeOsqueryd(t, `[{"uuid":"6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90","hardware_serial":"C02ABC123","hostname":"host.local","computer_name":"Host"}]`, 0)
+	identity, err := getHostIdentity(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"), defaultIdentityColumns)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"uuid":            "6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90",
+		"hardware_serial": "C02ABC123",
+		"hostname":        "host.local",
+		"computer_name":   "Host",
+	}, identity)
+
+	osquerydPath = writeFakeOsqueryd(t, `[{"uuid":"6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90"}]`, 0)
+	_, err = getHostIdentity(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"), defaultIdentityColumns)
+	require.ErrorContains(t, err, "hardware_serial field not found")
+}
+
+func TestDiscoverOsqueryd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake osqueryd script is not supported on Windows")
+	}
+	// Ignore osqueryd binaries installed in the test host.
+	searchPaths := osquerydSearchPaths
+	t.Cleanup(func() { osquerydSearchPaths = searchPaths })
+	osquerydSearchPaths = map[string][]string{}
+	t.Setenv("PATH", t.TempDir())
+	_, err := discoverOsqueryd()
+	require.Error(t, err)
+
+	osquerydPath := writeFakeOsqueryd(t, `[]`, 0)
+	t.Setenv("PATH", filepath.Dir(osquerydPath))
+	p, err := discoverOsqueryd()
+	require.NoError(t, err)
+	require.Equal(t, osquerydPath, p)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "sub", "uuid.txt")
+	require.NoError(t, writeFileAtomic(p, []byte("first\n"), 0o644))
+	require.NoError(t, writeFileAtomic(p, []byte("second\n"), 0o644))
+
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, "second\n", string(b))
+
+	entries, err := os.ReadDir(filepath.Dir(p))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+	}
+}
+
+func TestNewTempDB(t *testing.T) {
+	dir := t.TempDir()
+	dbPath, removeDB := newTempDB(dir, "serial")
+	require.Equal(t, dir, filepath.Dir(dbPath))
+	require.True(t, strings.HasPrefix(filepath.Base(dbPath), "orbit-serial-"))
+
+	require.NoError(t, os.MkdirAll(dbPath, 0o755))
+	removeDB()
+	require.NoDirExists(t, dbPath)
+
+	otherPath, _ := newTempDB(dir, "serial")
+	require.NotEqual(t, dbPath, otherPath)
+
+	dbPath, _ = newTempDB("", "query")
+	require.Equal(t, filepath.Clean(os.TempDir()), filepath.Dir(dbPath))
+}
+
+func TestValidateHostUUID(t *testing.T) {
+	require.NoError(t, validateHostUUID("6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90"))
+	require.NoError(t, validateHostUUID("6a1f2e62-0d27-4e6b-9f3c-2b5a7c1d8e90"))
+	require.ErrorContains(t, validateHostUUID(""), `invalid host UUID ""`)
+	require.ErrorContains(t, validateHostUUID("Not Settable"), `invalid host UUID "Not Settable"`)
+}
+
+func TestRunOsqueryQueryExtensionsAutoload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake osqueryd script is not supported on Windows")
+	}
+	// Fake osqueryd that returns its arguments.
+	osquerydPath := filepath.Join(t.TempDir(), "osqueryd")
+	require.NoError(t, os.WriteFile(osquerydPath, []byte("#!/bin/sh\nprintf '[{\"args\":\"%s\"}]' \"$*\"\n"), 0o755))
+
+	dbPath := filepath.Join(t.TempDir(), "db")
+	rows, err := runOsqueryQuery(context.Background(), osquerydPath, dbPath, `SELECT 1`, withExtensionsAutoload("/etc/osquery/extensions.load"))
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, fmt.Sprintf("-S --database_path %[1]s --extensions_autoload=/etc/osquery/extensions.load --extensions_socket=%[1]s.em --json SELECT 1", dbPath), rows[0]["args"])
+}
+
+func TestRunOsqueryQueryStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake osqueryd script is not supported on Windows")
+	}
+	osquerydPath := filepath.Join(t.TempDir(), "osqueryd")
+	require.NoError(t, os.WriteFile(osquerydPath, []byte("#!/bin/sh\necho 'W0101 deprecated flag' >&2\necho '[{\"uuid\":\"foo\"}]'\n"), 0o755))
+
+	var stderr bytes.Buffer
+	rows, err := runOsqueryQuery(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"), `SELECT uuid FROM system_info`, withStderr(&stderr))
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "W0101 deprecated flag\n", stderr.String())
+}
+
+func TestHashHostUUID(t *testing.T) {
+	const hostUUID = "6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90"
+	unsalted := hashHostUUID(hostUUID, "")
+	require.Len(t, unsalted, 64)
+	require.Equal(t, unsalted, hashHostUUID(hostUUID, ""))
+
+	salted := hashHostUUID(hostUUID, "pepper")
+	require.Len(t, salted, 64)
+	require.NotEqual(t, unsalted, salted)
+}
+
+func TestParseOsquerydVersion(t *testing.T) {
+	for _, tc := range []struct {
+		output   string
+		expected string
+		errMsg   string
+	}{
+		{output: "osqueryd version 5.12.1\n", expected: "5.12.1"},
+		{output: "osqueryd.exe version 5.10.2-26-gc396d07b4", expected: "5.10.2-26-gc396d07b4"},
+		{output: "osqueryd\n", errMsg: "unexpected osqueryd version output"},
+		{output: "", errMsg: "unexpected osqueryd version output"},
+	} {
+		version, err := parseOsquerydVersion(tc.output)
+		if tc.errMsg != "" {
+			require.ErrorContains(t, err, tc.errMsg)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, version)
+	}
+}
+
+func TestGetOsquerydVersion(t *testing.T) {
+	version, err := getOsquerydVersion(context.Background(), writeFakeOsqueryd(t, "osqueryd version 5.12.1", 0))
+	require.NoError(t, err)
+	require.Equal(t, "5.12.1", version)
+
+	_, err = getOsquerydVersion(context.Background(), writeFakeOsqueryd(t, "", 1))
+	require.ErrorContains(t, err, "osqueryd failed")
+}
+
+func TestGetHostUUIDSystemInfoUnavailable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake osqueryd script is not supported on Windows")
+	}
+	for _, tc := range []struct {
+		name   string
+		script string
+		errMsg string
+	}{
+		{
+			name:   "no such table",
+			script: "echo 'Error: no such table: system_info' >&2\nexit 1\n",
+			errMsg: errSystemInfoUnavailable.Error(),
+		},
+		{
+			name:   "not in registry",
+			script: "echo '[]'\n",
+			errMsg: errSystemInfoUnavailable.Error(),
+		},
+		{
+			name:   "in registry",
+			script: "case \"$*\" in *osquery_registry*) echo '[{\"name\":\"system_info\"}]' ;; *) echo '[]' ;; esac\n",
+			errMsg: "expected 1 row from query, got 0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			osquerydPath := filepath.Join(t.TempDir(), "osqueryd")
+			require.NoError(t, os.WriteFile(osquerydPath, []byte("#!/bin/sh\n"+tc.script), 0o755))
+			_, err := getHostUUID(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"))
+			require.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
+
+func TestWithExitCodes(t *testing.T) {
+	action := withExitCodes(func(c *cli.Context) error { return nil })
+	require.NoError(t, action(nil))
+
+	action = withExitCodes(func(c *cli.Context) error { return errors.New("boom") })
+	err := action(nil)
+	var exitCoder cli.ExitCoder
+	require.ErrorAs(t, err, &exitCoder)
+	require.Equal(t, exitFailure, exitCoder.ExitCode())
+	require.EqualError(t, err, "boom")
+
+	action = withExitCodes(func(c *cli.Context) error { return cli.Exit("not found", exitOsquerydNotFound) })
+	err = action(nil)
+	require.ErrorAs(t, err, &exitCoder)
+	require.Equal(t, exitOsquerydNotFound, exitCoder.ExitCode())
+
+	// urfave/cli only handles a cli.ExitCoder returned as is, not wrapped.
+	action = withExitCodes(func(c *cli.Context) error {
+		return fmt.Errorf("failed: %w", cli.Exit("not found", exitOsquerydNotFound))
+	})
+	err = action(nil)
+	exitCoder, ok := err.(cli.ExitCoder)
+	require.True(t, ok)
+	require.Equal(t, exitOsquerydNotFound, exitCoder.ExitCode())
+	require.EqualError(t, err, "failed: not found")
+}
+
+func TestLocateOsquerydExitCode(t *testing.T) {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("root-dir", t.TempDir(), "")
+	set.Bool("openframe-mode", true, "")
+	set.String("openframe-osquery-path", filepath.Join(t.TempDir(), "osqueryd"), "")
+	_, err := locateOsqueryd(cli.NewContext(cli.NewApp(), set, nil))
+	exitCoder, ok := err.(cli.ExitCoder)
+	require.True(t, ok)
+	require.Equal(t, exitOsquerydNotFound, exitCoder.ExitCode())
+	require.ErrorContains(t, err, "custom openframe osqueryd binary not found")
+}
+
+func TestOsqueryFailedExitCode(t *testing.T) {
+	osquerydPath := writeFakeOsqueryd(t, `boom`, 1)
+	for _, tc := range []struct {
+		command *cli.Command
+		args    []string
+	}{
+		{command: serialCommand},
+		{command: identityCommand},
+		{command: listTablesCommand},
+		{command: osqueryFlagsCommand},
+		{command: queryCommand, args: []string{"SELECT 1"}},
+		{command: versionCommand},
+	} {
+		t.Run(tc.command.Name, func(t *testing.T) {
+			app := cli.NewApp()
+			app.Flags = []cli.Flag{&cli.StringFlag{Name: "root-dir"}}
+			app.Commands = []*cli.Command{tc.command}
+			// Don't exit the test binary.
+			app.ExitErrHandler = func(*cli.Context, error) {}
+			err := app.Run(append([]string{
+				"orbit", "--root-dir", t.TempDir(), tc.command.Name,
+				"--openframe-mode", "--openframe-osquery-path", osquerydPath,
+			}, tc.args...))
+			exitCoder, ok := err.(cli.ExitCoder)
+			require.True(t, ok, err)
+			require.Equal(t, exitOsqueryFailed, exitCoder.ExitCode())
+		})
+	}
+}
+
+func TestValidateIdentityColumns(t *testing.T) {
+	require.NoError(t, validateIdentityColumns(defaultIdentityColumns))
+	require.NoError(t, validateIdentityColumns([]string{"hardware_model"}))
+	require.ErrorContains(t, validateIdentityColumns(nil), "at least one column")
+	require.ErrorContains(t, validateIdentityColumns([]string{"uuid", "uuid FROM users; --"}), `unknown column "uuid FROM users; --", allowed columns: board_model`)
+	require.EqualError(t, validateIdentityColumns([]string{"uuid", "hostname", "uuid"}), `duplicate column "uuid"`)
+}
+
+func TestReadOsquerydPathFile(t *testing.T) {
+	rootDir := t.TempDir()
+	p, err := readOsquerydPathFile(rootDir)
+	require.NoError(t, err)
+	require.Empty(t, p)
+
+	osquerydPath := filepath.Join(t.TempDir(), "osqueryd")
+	require.NoError(t, os.WriteFile(osquerydPath, nil, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, osquerydPathFileName), []byte(osquerydPath+"\n"), 0o644))
+	p, err = readOsquerydPathFile(rootDir)
+	require.NoError(t, err)
+	require.Equal(t, osquerydPath, p)
+
+	require.NoError(t, os.Remove(osquerydPath))
+	_, err = readOsquerydPathFile(rootDir)
+	require.ErrorContains(t, err, "not found: "+osquerydPath)
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	require.NoError(t, w.Close())
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestWithJSONErrors(t *testing.T) {
+	newContext := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.Bool("json", false, "")
+		set.String("format", "", "")
+		set.Bool("pretty", false, "")
+		require.NoError(t, set.Parse(args))
+		c := cli.NewContext(cli.NewApp(), set, nil)
+		c.Command = &cli.Command{Name: "uuid"}
+		return c
+	}
+	failing := func(c *cli.Context) error { return cli.Exit("failed to get host UUID: boom", exitOsqueryFailed) }
+
+	var err error
+	out := captureStdout(t, func() {
+		err = withJSONErrors(isOutputFormat("json", uuidFormats...), []string{"uuid"}, failing)(newContext("--json"))
+	})
+	require.EqualError(t, err, "failed to get host UUID: boom")
+	require.JSONEq(t, `{"schema_version":1,"command":"uuid","data":{"uuid":null},"error":"failed to get host UUID: boom"}`, out)
+
+	out = captureStdout(t, func() {
+		err = withJSONErrors(isOutputFormat("json", uuidFormats...), []string{"uuid"}, failing)(newContext())
+	})
+	require.Error(t, err)
+	require.Empty(t, out)
+
+	out = captureStdout(t, func() {
+		err = withJSONErrors(isOutputFormat("json", uuidFormats...), []string{"uuid"}, failing)(newContext("--format", "json"))
+	})
+	require.Error(t, err)
+	require.Contains(t, out, `"error":"failed to get host UUID: boom"`)
+
+	out = captureStdout(t, func() {
+		err = withJSONErrors(func(*cli.Context) bool { return true }, nil, failing)(newContext())
+	})
+	require.Error(t, err)
+	require.JSONEq(t, `{"schema_version":1,"command":"uuid","data":null,"error":"failed to get host UUID: boom"}`, out)
+
+	out = captureStdout(t, func() {
+		err = withJSONErrors(isOutputFormat("json", uuidFormats...), []string{"uuid"}, func(c *cli.Context) error { return nil })(newContext("--json"))
+	})
+	require.NoError(t, err)
+	require.Empty(t, out)
+}
+
+func TestNewLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(newLogWriter(&buf, "json"))
+	logger.Info().Str("path", "/opt/orbit").Msg("hello")
+	require.JSONEq(t, `{"level":"info","path":"/opt/orbit","message":"hello"}`, buf.String())
+
+	buf.Reset()
+	logger = zerolog.New(newLogWriter(&buf, "console"))
+	logger.Info().Str("path", "/opt/orbit").Msg("hello")
+	require.Contains(t, buf.String(), "INF hello path=/opt/orbit")
+}
+
+func TestMarshalEnvelope(t *testing.T) {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	c := cli.NewContext(cli.NewApp(), set, nil)
+	c.Command = &cli.Command{Name: "serial"}
+	out, err := marshalEnvelope(c, map[string]string{"hardware_serial": "C02ABC123"}, "")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"schema_version":1,"command":"serial","data":{"hardware_serial":"C02ABC123"}}`, string(out))
+}
+
+func TestOutputFormat(t *testing.T) {
+	newContext := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.Bool("json", false, "")
+		set.String("format", "", "")
+		require.NoError(t, set.Parse(args))
+		return cli.NewContext(cli.NewApp(), set, nil)
+	}
+	for _, tc := range []struct {
+		args     []string
+		expected string
+		errMsg   string
+	}{
+		{expected: "plain"},
+		{args: []string{"--json"}, expected: "json"},
+		{args: []string{"--format", "env"}, expected: "env"},
+		{args: []string{"--json", "--format", "json"}, expected: "json"},
+		{args: []string{"--json", "--format", "env"}, errMsg: "json and format cannot be used together"},
+		{args: []string{"--format", "yaml"}, errMsg: `unsupported format "yaml", supported: plain, json, env`},
+	} {
+		format, err := outputFormat(newContext(tc.args...), uuidFormats...)
+		if tc.errMsg != "" {
+			require.ErrorContains(t, err, tc.errMsg)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, format)
+	}
+}
+
+func TestEnvAssignment(t *testing.T) {
+	require.Equal(t, "ORBIT_HOST_UUID=6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90", envAssignment("ORBIT_HOST_UUID", "6A1F2E62-0D27-4E6B-9F3C-2B5A7C1D8E90"))
+	require.Equal(t, "ORBIT_HOST_HOSTNAME=host.local", envAssignment("ORBIT_HOST_HOSTNAME", "host.local"))
+	require.Equal(t, "ORBIT_HOST_COMPUTER_NAME='Bob'\\''s MacBook'", envAssignment("ORBIT_HOST_COMPUTER_NAME", "Bob's MacBook"))
+	require.Equal(t, "ORBIT_HOST_HARDWARE_SERIAL=''", envAssignment("ORBIT_HOST_HARDWARE_SERIAL", ""))
+}
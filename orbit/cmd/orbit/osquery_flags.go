@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Openframe command that prints the effective osqueryd flag values, as
+// reported by the osquery_flags table, for troubleshooting.
+var osqueryFlagsCommand = &cli.Command{
+	Name:  "flags",
+	Usage: "Print the effective osqueryd flags as JSON",
+	Flags: append([]cli.Flag{
+		prettyFlag(),
+	}, openframeFlags()...),
+	Action: withExitCodes(func(c *cli.Context) error {
+		osquerydPath, err := locateOsqueryd(c)
+		if err != nil {
+			return err
+		}
+
+		tmpDBPath, removeDB := newTempDB("", "flags")
+		defer removeDB()
+
+		flags, err := getOsqueryFlags(context.Background(), osquerydPath, tmpDBPath)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("failed to get osquery flags: %w", err), exitOsqueryFailed)
+		}
+
+		out, err := marshalEnvelope(c, flags, "")
+		if err != nil {
+			return fmt.Errorf("failed to marshal osquery flags: %w", err)
+		}
+		fmt.Println(string(out))
+		return nil
+	}),
+}
+
+// osqueryFlag is the value of an osqueryd flag as reported by osquery_flags.
+type osqueryFlag struct {
+	Type         string `json:"type"`
+	Value        string `json:"value"`
+	DefaultValue string `json:"default_value"`
+	Description  string `json:"description"`
+}
+
+// getOsqueryFlags returns the osqueryd flags keyed by flag name.
+func getOsqueryFlags(ctx context.Context, osquerydPath string, dbPath string, opts ...queryOption) (map[string]osqueryFlag, error) {
+	rows, err := runOsqueryQuery(ctx, osquerydPath, dbPath,
+		`SELECT name, type, value, default_value, description FROM osquery_flags`, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]osqueryFlag, len(rows))
+	for _, row := range rows {
+		name, ok := row["name"].(string)
+		if !ok {
+			return nil, errors.New("name field not found or not a string")
+		}
+		// Columns are all strings in osquery JSON output.
+		flagType, _ := row["type"].(string)
+		value, _ := row["value"].(string)
+		defaultValue, _ := row["default_value"].(string)
+		description, _ := row["description"].(string)
+		flags[name] = osqueryFlag{
+			Type:         flagType,
+			Value:        value,
+			DefaultValue: defaultValue,
+			Description:  description,
+		}
+	}
+	return flags, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOsqueryFlags(t *testing.T) {
+	osquerydPath := writeFakeOsqueryd(t, `[
+{"name":"verbose","type":"bool","value":"true","default_value":"false","description":"Enable verbose informational messages"},
+{"name":"logger_plugin","type":"string","value":"filesystem","default_value":"filesystem","description":"Logger plugin name"}
+]`, 0)
+	flags, err := getOsqueryFlags(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]osqueryFlag{
+		"verbose": {
+			Type:         "bool",
+			Value:        "true",
+			DefaultValue: "false",
+			Description:  "Enable verbose informational messages",
+		},
+		"logger_plugin": {
+			Type:         "string",
+			Value:        "filesystem",
+			DefaultValue: "filesystem",
+			Description:  "Logger plugin name",
+		},
+	}, flags)
+
+	osquerydPath = writeFakeOsqueryd(t, `[{"value":"true"}]`, 0)
+	_, err = getOsqueryFlags(context.Background(), osquerydPath, filepath.Join(t.TempDir(), "db"))
+	require.ErrorContains(t, err, "name field not found")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Openframe command that runs an arbitrary query (or a file of queries) with
+// osqueryd using a temporary database and prints all the returned rows as JSON.
+var queryCommand = &cli.Command{
+	Name:      "query",
+	Usage:     "Run an osquery SQL query and print the results as JSON",
+	ArgsUsage: "<sql>",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "file",
+			Usage: "Run the semicolon-separated queries in this file instead, printing a JSON object mapping each query to its rows",
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Maximum time to wait for osqueryd to run the queries (0 means no timeout)",
+		},
+		prettyFlag(),
+		&cli.StringFlag{
+			Name:  "extensions-autoload",
+			Usage: "Path to an osquery extensions autoload file, for tables provided by extensions",
+		},
+	}, openframeFlags()...),
+	Action: withExitCodes(func(c *cli.Context) error {
+		file := c.String("file")
+		var queries []string
+		if file != "" {
+			if c.NArg() != 0 {
+				return errors.New("a SQL query argument cannot be provided with --file")
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read queries file: %w", err)
+			}
+			queries = splitQueries(string(b))
+			if len(queries) == 0 {
+				return fmt.Errorf("no queries found in %s", file)
+			}
+		} else {
+			if c.NArg() != 1 {
+				return errors.New("exactly one SQL query argument must be provided")
+			}
+			queries = []string{c.Args().First()}
+		}
+
+		osquerydPath, err := locateOsqueryd(c)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		timeout := c.Duration("timeout")
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		tmpDBPath, removeDB := newTempDB("", "query")
+		defer removeDB()
+
+		var opts []queryOption
+		if p := c.String("extensions-autoload"); p != "" {
+			opts = append(opts, withExtensionsAutoload(p))
+		}
+
+		// All queries share the temporary database.
+		results := make(map[string][]map[string]interface{}, len(queries))
+		for _, sql := range queries {
+			rows, err := runOsqueryQuery(ctx, osquerydPath, tmpDBPath, sql, opts...)
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					return cli.Exit(fmt.Errorf("osquery query timed out after %s", timeout), exitOsqueryFailed)
+				}
+				if file != "" {
+					return cli.Exit(fmt.Errorf("failed to run query %q: %w", sql, err), exitOsqueryFailed)
+				}
+				return cli.Exit(fmt.Errorf("failed to run query: %w", err), exitOsqueryFailed)
+			}
+			if rows == nil {
+				rows = []map[string]interface{}{}
+			}
+			results[sql] = rows
+		}
+
+		var output interface{} = results
+		if file == "" {
+			output = results[queries[0]]
+		}
+		out, err := marshalEnvelope(c, output, "")
+		if err != nil {
+			return fmt.Errorf("failed to marshal query results: %w", err)
+		}
+		fmt.Println(string(out))
+		return nil
+	}),
+}
+
+// splitQueries returns the semicolon-separated queries in content, skipping
+// empty queries and "--" comment lines. Semicolons are not allowed inside
+// string literals.
+func splitQueries(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	var queries []string
+	for _, query := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if query = strings.TrimSpace(query); query != "" {
+			queries = append(queries, query)
+		}
+	}
+	return queries
+}
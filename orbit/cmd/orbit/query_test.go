@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitQueries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name:    "empty",
+			content: "",
+		},
+		{
+			name:     "single query without semicolon",
+			content:  "SELECT * FROM os_version\n",
+			expected: []string{"SELECT * FROM os_version"},
+		},
+		{
+			name: "multiple queries with comments",
+			content: `-- host information
+SELECT uuid FROM system_info;
+SELECT name,
+  version
+FROM os_version;
+
+-- trailing comment;
+`,
+			expected: []string{"SELECT uuid FROM system_info", "SELECT name,\n  version\nFROM os_version"},
+		},
+		{
+			name:    "only separators",
+			content: " ; ;\n;",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, splitQueries(tc.content))
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Openframe command that runs a few queries with osqueryd end to end, to check
+// the deployed binary works on the host before relying on it.
+var selfTestCommand = &cli.Command{
+	Name:  "selftest",
+	Usage: "Run test queries with osqueryd and report their latency",
+	Flags: append([]cli.Flag{
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Maximum time to wait for osqueryd to run each query",
+			Value: 30 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output results in JSON format",
+		},
+		prettyFlag(),
+	}, openframeFlags()...),
+	Action: withExitCodes(func(c *cli.Context) error {
+		if c.Duration("timeout") <= 0 {
+			return errors.New("timeout must be greater than zero")
+		}
+
+		osquerydPath, err := locateOsqueryd(c)
+		if err != nil {
+			return err
+		}
+
+		tmpDBPath, removeDB := newTempDB("", "selftest")
+		defer removeDB()
+
+		results := runSelfTest(osquerydPath, tmpDBPath, c.Duration("timeout"))
+
+		if c.Bool("json") {
+			out, err := marshalEnvelope(c, results, "")
+			if err != nil {
+				return fmt.Errorf("failed to marshal self-test results: %w", err)
+			}
+			fmt.Println(string(out))
+		} else {
+			for _, result := range results {
+				if result.Passed {
+					fmt.Printf("PASS %6dms %s\n", result.LatencyMS, result.Query)
+				} else {
+					fmt.Printf("FAIL %6dms %s: %s\n", result.LatencyMS, result.Query, result.Error)
+				}
+			}
+		}
+
+		var failed int
+		for _, result := range results {
+			if !result.Passed {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return cli.Exit(fmt.Sprintf("%d of %d queries failed", failed, len(results)), exitOsqueryFailed)
+		}
+		return nil
+	}),
+}
+
+// selfTestQueries are the queries run by the selftest command. Each must return
+// at least one row.
+var selfTestQueries = []string{
+	"SELECT 1",
+	"SELECT uuid FROM system_info",
+	"SELECT COUNT(*) AS count FROM processes",
+}
+
+// selfTestResult is the outcome of one of the selfTestQueries.
+type selfTestResult struct {
+	Query     string `json:"query"`
+	Passed    bool   `json:"passed"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runSelfTest runs selfTestQueries with osqueryd using the provided (temporary)
+// database path, each killed if it takes longer than timeout.
+func runSelfTest(osquerydPath, dbPath string, timeout time.Duration) []selfTestResult {
+	results := make([]selfTestResult, 0, len(selfTestQueries))
+	for _, sql := range selfTestQueries {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		rows, err := runOsqueryQuery(ctx, osquerydPath, dbPath, sql)
+		latency := time.Since(start)
+		cancel()
+
+		if err == nil && len(rows) == 0 {
+			err = errors.New("no rows returned")
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("osquery query timed out after %s", timeout)
+		}
+		result := selfTestResult{Query: sql, Passed: err == nil, LatencyMS: latency.Milliseconds()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	osquerydPath := writeFakeOsqueryd(t, `[{"1":"1"}]`, 0)
+	results := runSelfTest(osquerydPath, filepath.Join(t.TempDir(), "db"), 5*time.Second)
+	require.Len(t, results, len(selfTestQueries))
+	for i, result := range results {
+		require.Equal(t, selfTestQueries[i], result.Query)
+		require.True(t, result.Passed, result.Error)
+		require.Empty(t, result.Error)
+	}
+
+	osquerydPath = writeFakeOsqueryd(t, `[]`, 0)
+	results = runSelfTest(osquerydPath, filepath.Join(t.TempDir(), "db"), 5*time.Second)
+	for _, result := range results {
+		require.False(t, result.Passed)
+		require.Equal(t, "no rows returned", result.Error)
+	}
+
+	slowPath := filepath.Join(t.TempDir(), "osqueryd")
+	require.NoError(t, os.WriteFile(slowPath, []byte("#!/bin/sh\nexec sleep 10\n"), 0o755))
+	results = runSelfTest(slowPath, filepath.Join(t.TempDir(), "db"), 100*time.Millisecond)
+	for _, result := range results {
+		require.False(t, result.Passed)
+		require.Equal(t, "osquery query timed out after 100ms", result.Error)
+		require.Positive(t, result.LatencyMS)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/update"
+	"github.com/micromdm/plist"
+)
+
+// rootDirFromServiceConfig returns the root directory orbit uses when started
+// with the given command line (including the executable) and environment
+// variables, mirroring how the root-dir flag is resolved in main.
+func rootDirFromServiceConfig(args []string, env map[string]string) (string, error) {
+	if len(args) > 0 {
+		if v, ok := rootDirArg(args[1:]); ok {
+			return v, nil
+		}
+	}
+	if v := env["ORBIT_ROOT_DIR"]; v != "" {
+		return v, nil
+	}
+	if len(args) == 0 {
+		return "", errors.New("no orbit command found in service definition")
+	}
+	// handle old installations, which had default root dir set to /var/lib/orbit
+	if strings.HasPrefix(args[0], "/var/lib/orbit") {
+		return "/var/lib/orbit", nil
+	}
+	return update.DefaultOptions.RootDirectory, nil
+}
+
+// rootDirArg returns the value of the root-dir flag in the command line
+// arguments args (without the executable), if present.
+func rootDirArg(args []string) (string, bool) {
+	for i, arg := range args {
+		for _, name := range []string{"--root-dir", "-root-dir"} {
+			if v, ok := strings.CutPrefix(arg, name+"="); ok {
+				return v, true
+			}
+			if arg == name && i+1 < len(args) {
+				return args[i+1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// launchdService holds the fields of a launchd plist used to start orbit.
+type launchdService struct {
+	EnvironmentVariables map[string]string `plist:"EnvironmentVariables"`
+	ProgramArguments     []string          `plist:"ProgramArguments"`
+}
+
+// parseLaunchdService returns the command line and environment variables of
+// the launchd plist in data.
+func parseLaunchdService(data []byte) ([]string, map[string]string, error) {
+	var svc launchdService
+	if err := plist.Unmarshal(data, &svc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse launchd plist: %w", err)
+	}
+	return svc.ProgramArguments, svc.EnvironmentVariables, nil
+}
+
+// parseSystemdService returns the command line, the environment files and the
+// environment variables set in the [Service] section of the systemd unit in
+// data. Quoting in ExecStart is not supported.
+func parseSystemdService(data []byte) (args []string, envFiles []string, env map[string]string) {
+	env = make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "ExecStart":
+			// Prefixes such as "-" or "@" change how systemd runs the command.
+			args = strings.Fields(strings.TrimLeft(strings.TrimSpace(value), "-@:+!"))
+		case "EnvironmentFile":
+			// A "-" prefix means the file is optional.
+			envFiles = append(envFiles, strings.TrimPrefix(strings.TrimSpace(value), "-"))
+		case "Environment":
+			for _, assignment := range strings.Fields(value) {
+				k, v, ok := strings.Cut(strings.Trim(assignment, `"`), "=")
+				if ok {
+					env[k] = v
+				}
+			}
+		}
+	}
+	return args, envFiles, env
+}
+
+// parseEnvFile returns the variables assigned in the environment file in data,
+// in the KEY=value format used by systemd EnvironmentFile.
+func parseEnvFile(data []byte) map[string]string {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		env[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+	}
+	return env
+}
@@ -0,0 +1,29 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const launchdPlistPath = "/Library/LaunchDaemons/com.fleetdm.orbit.plist"
+
+// serviceRootDir returns the root directory the installed orbit launchd
+// daemon runs with.
+func serviceRootDir() (string, error) {
+	data, err := os.ReadFile(launchdPlistPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", errors.New("orbit launchd daemon not found")
+		}
+		return "", fmt.Errorf("read launchd plist: %w", err)
+	}
+	args, env, err := parseLaunchdService(data)
+	if err != nil {
+		return "", err
+	}
+	return rootDirFromServiceConfig(args, env)
+}
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// systemdUnitPaths are the locations of the orbit systemd unit, in order of
+// precedence.
+var systemdUnitPaths = []string{
+	"/etc/systemd/system/orbit.service",
+	"/usr/lib/systemd/system/orbit.service",
+}
+
+// serviceRootDir returns the root directory the installed orbit systemd
+// service runs with.
+func serviceRootDir() (string, error) {
+	for _, unitPath := range systemdUnitPaths {
+		data, err := os.ReadFile(unitPath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return "", fmt.Errorf("read systemd unit: %w", err)
+		}
+		args, envFiles, env := parseSystemdService(data)
+		for _, envFile := range envFiles {
+			b, err := os.ReadFile(envFile)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return "", fmt.Errorf("read systemd environment file: %w", err)
+			}
+			for k, v := range parseEnvFile(b) {
+				// Environment= settings take precedence over EnvironmentFile=.
+				if _, ok := env[k]; !ok {
+					env[k] = v
+				}
+			}
+		}
+		return rootDirFromServiceConfig(args, env)
+	}
+	return "", errors.New("orbit systemd service not found")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/update"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootDirFromServiceConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "default",
+			args:     []string{"/opt/orbit/bin/orbit/orbit"},
+			expected: update.DefaultOptions.RootDirectory,
+		},
+		{
+			name:     "old installation",
+			args:     []string{"/var/lib/orbit/bin/orbit/orbit"},
+			expected: "/var/lib/orbit",
+		},
+		{
+			name:     "environment",
+			args:     []string{"/opt/orbit/bin/orbit/orbit"},
+			env:      map[string]string{"ORBIT_ROOT_DIR": "/srv/orbit"},
+			expected: "/srv/orbit",
+		},
+		{
+			name:     "flag takes precedence",
+			args:     []string{"/opt/orbit/bin/orbit/orbit", "--debug", "--root-dir", "/data/orbit"},
+			env:      map[string]string{"ORBIT_ROOT_DIR": "/srv/orbit"},
+			expected: "/data/orbit",
+		},
+		{
+			name:     "flag with equals",
+			args:     []string{"/opt/orbit/bin/orbit/orbit", "--root-dir=/data/orbit"},
+			expected: "/data/orbit",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir, err := rootDirFromServiceConfig(tc.args, tc.env)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, rootDir)
+		})
+	}
+
+	_, err := rootDirFromServiceConfig(nil, nil)
+	require.Error(t, err)
+}
+
+func TestRootDirArg(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected string
+		ok       bool
+	}{
+		{args: nil},
+		{args: []string{"--root-dir-from-service"}},
+		{args: []string{"--root-dir"}},
+		{args: []string{"--debug", "--root-dir", "/data/orbit"}, expected: "/data/orbit", ok: true},
+		{args: []string{"-root-dir", "/data/orbit"}, expected: "/data/orbit", ok: true},
+		{args: []string{"--root-dir=/data/orbit"}, expected: "/data/orbit", ok: true},
+	} {
+		v, ok := rootDirArg(tc.args)
+		require.Equal(t, tc.ok, ok, tc.args)
+		require.Equal(t, tc.expected, v, tc.args)
+	}
+}
+
+func TestParseSystemdService(t *testing.T) {
+	args, envFiles, env := parseSystemdService([]byte(`
+[Unit]
+Description=Orbit osquery
+
+[Service]
+EnvironmentFile=-/etc/default/orbit
+Environment="ORBIT_DEBUG=true" ORBIT_ROOT_DIR=/srv/orbit
+ExecStart=/opt/orbit/bin/orbit/orbit --insecure
+`))
+	require.Equal(t, []string{"/opt/orbit/bin/orbit/orbit", "--insecure"}, args)
+	require.Equal(t, []string{"/etc/default/orbit"}, envFiles)
+	require.Equal(t, map[string]string{"ORBIT_DEBUG": "true", "ORBIT_ROOT_DIR": "/srv/orbit"}, env)
+}
+
+func TestParseEnvFile(t *testing.T) {
+	env := parseEnvFile([]byte(`
+# comment
+ORBIT_UPDATE_URL=https://tuf.fleetctl.com
+ORBIT_ROOT_DIR="/srv/orbit"
+
+ORBIT_INSECURE=true
+`))
+	require.Equal(t, map[string]string{
+		"ORBIT_UPDATE_URL": "https://tuf.fleetctl.com",
+		"ORBIT_ROOT_DIR":   "/srv/orbit",
+		"ORBIT_INSECURE":   "true",
+	}, env)
+}
+
+func TestParseLaunchdService(t *testing.T) {
+	args, env, err := parseLaunchdService([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>ORBIT_ROOT_DIR</key>
+		<string>/srv/orbit</string>
+	</dict>
+	<key>Label</key>
+	<string>com.fleetdm.orbit</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>/opt/orbit/bin/orbit/orbit</string>
+	</array>
+</dict>
+</plist>
+`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"/opt/orbit/bin/orbit/orbit"}, args)
+	require.Equal(t, map[string]string{"ORBIT_ROOT_DIR": "/srv/orbit"}, env)
+
+	_, _, err = parseLaunchdService([]byte("not a plist"))
+	require.Error(t, err)
+}
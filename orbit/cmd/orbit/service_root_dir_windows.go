@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "errors"
+
+// serviceRootDir is not supported on Windows, where the installer always
+// passes --root-dir to the service.
+func serviceRootDir() (string, error) {
+	return "", errors.New("reading the root directory from the service is not supported on Windows")
+}
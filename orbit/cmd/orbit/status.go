@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/constant"
+	"github.com/fleetdm/fleet/v4/orbit/pkg/platform"
+	"github.com/urfave/cli/v2"
+)
+
+// Openframe command that reports the enrollment state of the agent.
+var statusCommand = &cli.Command{
+	Name:  "status",
+	Usage: "Report the enrollment state of the agent",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output status in JSON format",
+		},
+		prettyFlag(),
+	},
+	Action: withExitCodes(func(c *cli.Context) error {
+		st, err := getAgentStatus(c.String("root-dir"))
+		if err != nil {
+			return err
+		}
+
+		if c.Bool("json") {
+			out, err := marshalEnvelope(c, st, "")
+			if err != nil {
+				return fmt.Errorf("failed to marshal status: %w", err)
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		fmt.Printf("Root directory: %s\n", st.RootDir)
+		fmt.Printf("Node key (%s): %s\n", constant.OrbitNodeKeyFileName, st.NodeKey)
+		fmt.Printf("Enroll secret (%s): %s\n", constant.OsqueryEnrollSecretFileName, st.EnrollSecret)
+		fmt.Printf("Desktop token (%s): %s\n", constant.DesktopTokenFileName, st.DesktopToken)
+		if st.OsquerydRunning {
+			fmt.Println("osqueryd: running")
+		} else {
+			fmt.Println("osqueryd: not running")
+		}
+		if st.Enrolled {
+			fmt.Println("Status: enrolled")
+		} else {
+			fmt.Println("Status: not enrolled")
+		}
+		return nil
+	}),
+}
+
+// agentStatus holds the enrollment state of the agent as found on disk.
+type agentStatus struct {
+	RootDir         string         `json:"root_dir"`
+	Enrolled        bool           `json:"enrolled"`
+	NodeKey         artifactStatus `json:"node_key"`
+	EnrollSecret    artifactStatus `json:"enroll_secret"`
+	DesktopToken    artifactStatus `json:"desktop_token"`
+	OsquerydRunning bool           `json:"osqueryd_running"`
+}
+
+// artifactStatus holds the state of a single enrollment file.
+type artifactStatus struct {
+	Exists   bool `json:"exists"`
+	Readable bool `json:"readable"`
+}
+
+func (a artifactStatus) String() string {
+	switch {
+	case !a.Exists:
+		return "missing"
+	case !a.Readable:
+		return "present (not readable)"
+	default:
+		return "present"
+	}
+}
+
+func getAgentStatus(rootDir string) (*agentStatus, error) {
+	st := &agentStatus{
+		RootDir:      rootDir,
+		NodeKey:      getArtifactStatus(filepath.Join(rootDir, constant.OrbitNodeKeyFileName)),
+		EnrollSecret: getArtifactStatus(filepath.Join(rootDir, constant.OsqueryEnrollSecretFileName)),
+		DesktopToken: getArtifactStatus(filepath.Join(rootDir, constant.DesktopTokenFileName)),
+	}
+	// The enroll secret file is deleted once the secret is moved to the system
+	// keystore (macOS and Windows), so only the node key determines enrollment.
+	st.Enrolled = st.NodeKey.Exists && st.NodeKey.Readable
+
+	running, err := isOsquerydRunning()
+	if err != nil {
+		return nil, err
+	}
+	st.OsquerydRunning = running
+	return st, nil
+}
+
+func getArtifactStatus(path string) artifactStatus {
+	f, err := os.Open(path)
+	if err != nil {
+		return artifactStatus{Exists: !errors.Is(err, os.ErrNotExist)}
+	}
+	f.Close()
+	return artifactStatus{Exists: true, Readable: true}
+}
+
+// isOsquerydRunning returns whether there's an osqueryd process running.
+func isOsquerydRunning() (bool, error) {
+	_, err := platform.GetProcessesByName(constant.OsqueryTUFTargetName)
+	switch {
+	case errors.Is(err, platform.ErrProcessNotFound):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to list osqueryd processes: %w", err)
+	default:
+		return true, nil
+	}
+}
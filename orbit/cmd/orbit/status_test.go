@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/constant"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAgentStatus(t *testing.T) {
+	rootDir := t.TempDir()
+
+	st, err := getAgentStatus(rootDir)
+	require.NoError(t, err)
+	require.False(t, st.Enrolled)
+	require.Equal(t, artifactStatus{}, st.NodeKey)
+	require.Equal(t, "missing", st.NodeKey.String())
+
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, constant.OrbitNodeKeyFileName), []byte("key"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, constant.DesktopTokenFileName), []byte("token"), 0o600))
+
+	st, err = getAgentStatus(rootDir)
+	require.NoError(t, err)
+	require.True(t, st.Enrolled)
+	require.Equal(t, artifactStatus{Exists: true, Readable: true}, st.NodeKey)
+	require.Equal(t, artifactStatus{Exists: true, Readable: true}, st.DesktopToken)
+	require.Equal(t, artifactStatus{}, st.EnrollSecret)
+	require.Equal(t, "present", st.NodeKey.String())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/constant"
+	"github.com/urfave/cli/v2"
+)
+
+// Openframe command that reports the node key identity of the agent, by
+// default as a fingerprint so the secret isn't exposed.
+var whoamiCommand = &cli.Command{
+	Name:  "whoami",
+	Usage: "Report the node key identity of the agent",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "show-secret",
+			Usage: "Output the full node key instead of its fingerprint",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output identity in JSON format",
+		},
+		prettyFlag(),
+	},
+	Action: withExitCodes(func(c *cli.Context) error {
+		id, err := getNodeIdentity(c.String("root-dir"), c.Bool("show-secret"))
+		if err != nil {
+			return err
+		}
+
+		if c.Bool("json") {
+			out, err := marshalEnvelope(c, id, "")
+			if err != nil {
+				return fmt.Errorf("failed to marshal identity: %w", err)
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		switch {
+		case !id.Enrolled:
+			fmt.Println("Node key: missing")
+		case id.NodeKey != "":
+			fmt.Printf("Node key: %s\n", id.NodeKey)
+		default:
+			fmt.Printf("Node key fingerprint: sha256:%s\n", id.NodeKeyFingerprint)
+		}
+		fmt.Printf("Desktop token: %s\n", id.DesktopToken)
+		return nil
+	}),
+}
+
+// nodeIdentity is the node key identity reported by the whoami command.
+type nodeIdentity struct {
+	Enrolled           bool           `json:"enrolled"`
+	NodeKeyFingerprint string         `json:"node_key_fingerprint,omitempty"`
+	NodeKey            string         `json:"node_key,omitempty"`
+	DesktopToken       artifactStatus `json:"desktop_token"`
+}
+
+// getNodeIdentity returns the node key identity found in rootDir. The node key
+// itself is only included if showSecret is set.
+func getNodeIdentity(rootDir string, showSecret bool) (*nodeIdentity, error) {
+	id := &nodeIdentity{
+		DesktopToken: getArtifactStatus(filepath.Join(rootDir, constant.DesktopTokenFileName)),
+	}
+	b, err := os.ReadFile(filepath.Join(rootDir, constant.OrbitNodeKeyFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return id, nil
+		}
+		return nil, fmt.Errorf("failed to read node key: %w", err)
+	}
+	nodeKey := strings.TrimSpace(string(b))
+	if nodeKey == "" {
+		return id, nil
+	}
+	id.Enrolled = true
+	id.NodeKeyFingerprint = nodeKeyFingerprint(nodeKey)
+	if showSecret {
+		id.NodeKey = nodeKey
+	}
+	return id, nil
+}
+
+// nodeKeyFingerprint returns the first 16 hex characters of the SHA-256 digest
+// of nodeKey, enough to tell node keys apart without revealing them.
+func nodeKeyFingerprint(nodeKey string) string {
+	sum := sha256.Sum256([]byte(nodeKey))
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/orbit/pkg/constant"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNodeIdentity(t *testing.T) {
+	rootDir := t.TempDir()
+	id, err := getNodeIdentity(rootDir, true)
+	require.NoError(t, err)
+	require.Equal(t, &nodeIdentity{}, id)
+
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, constant.OrbitNodeKeyFileName), []byte("supersecretnodekey\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, constant.DesktopTokenFileName), []byte("token"), 0o600))
+	id, err = getNodeIdentity(rootDir, false)
+	require.NoError(t, err)
+	require.Equal(t, &nodeIdentity{
+		Enrolled:           true,
+		NodeKeyFingerprint: nodeKeyFingerprint("supersecretnodekey"),
+		DesktopToken:       artifactStatus{Exists: true, Readable: true},
+	}, id)
+	require.Len(t, id.NodeKeyFingerprint, 16)
+
+	id, err = getNodeIdentity(rootDir, true)
+	require.NoError(t, err)
+	require.Equal(t, "supersecretnodekey", id.NodeKey)
+}
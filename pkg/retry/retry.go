@@ -2,6 +2,7 @@
 package retry
 
 import (
+	"context"
 	"time"
 )
 
@@ -31,6 +32,7 @@ type config struct {
 	backoffMultiplier int
 	maxAttempts       int
 	errorFilter       func(error) ErrorOutcome
+	ctx               context.Context
 }
 
 // Option allows to configure the behavior of retry.Do
@@ -67,6 +69,14 @@ func WithErrorFilter(f func(error) ErrorOutcome) Option {
 	}
 }
 
+// WithContext stops waiting between retries when ctx is done, in which case
+// the last error returned by the operation is returned.
+func WithContext(ctx context.Context) Option {
+	return func(c *config) {
+		c.ctx = ctx
+	}
+}
+
 // Do executes the provided function, if the function returns a
 // non-nil error it performs a retry according to the options
 // provided.
@@ -76,6 +86,7 @@ func WithErrorFilter(f func(error) ErrorOutcome) Option {
 func Do(fn func() error, opts ...Option) error {
 	cfg := &config{
 		initialInterval: 30 * time.Second,
+		ctx:             context.Background(),
 	}
 	for _, opt := range opts {
 		opt(cfg)
@@ -116,6 +127,10 @@ func Do(fn func() error, opts ...Option) error {
 			ticker.Reset(interval)
 		}
 
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-cfg.ctx.Done():
+			return err
+		}
 	}
 }
@@ -1,6 +1,7 @@
 package retry
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -143,4 +144,19 @@ func TestRetryDo(t *testing.T) {
 		require.ErrorContains(t, err, "stop")
 		require.Equal(t, 3, count)
 	})
+
+	t.Run("WithContext stops waiting when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		count := 0
+		start := time.Now()
+		err := Do(func() error {
+			count++
+			cancel()
+			return errTest
+		}, WithContext(ctx), WithInterval(10*time.Second))
+
+		require.ErrorIs(t, err, errTest)
+		require.Equal(t, 1, count)
+		require.Less(t, time.Since(start), 1*time.Second)
+	})
 }